@@ -7,50 +7,74 @@ import (
 )
 
 type authMessage struct {
-	c1, r1, c2, r2, c3, r3 ed448.Scalar // XXX: serialize as MPI
+	c1, r1, c2, r2, c3, r3 ed448.Scalar
 }
 
-func (sigma *authMessage) generateAuthParams(rand io.Reader) error {
-	var err1, err2, err3, err4 error
+const authMessageBytes = 6 * fieldBytes
 
-	sigma.c2, err1 = randScalar(rand)
-	sigma.c3, err2 = randScalar(rand)
-	sigma.r2, err3 = randScalar(rand)
-	sigma.r3, err4 = randScalar(rand)
-
-	return firstError(err1, err2, err3, err4)
+// ringStatements are the statements of the DAKE ring signature: knowledge
+// of the secret of any one of the three keys
+func ringStatements(pubs ...ed448.Point) []*sigmaStatement {
+	out := make([]*sigmaStatement, len(pubs))
+	for i, p := range pubs {
+		out[i] = knowledgeStatement(p, nil)
+	}
+	return out
 }
 
-func (sigma *authMessage) auth(rand io.Reader, ourPub, theirPub, theirPubEcdh ed448.Point, ourSec ed448.Scalar, message []byte) error {
-	t1, err := randScalar(rand)
-	if err != nil {
-		return err
+// ringChallenge binds the ring signature to the keys and the message
+func ringChallenge(pub1, pub2, pub3 ed448.Point, message []byte) fiatShamir {
+	return func(t []ed448.Point) ed448.Scalar {
+		return appendAndHash(ed448.BasePoint, ed448.ScalarQ, pub1, pub2, pub3, t[0], t[1], t[2], message)
 	}
+}
 
-	err = sigma.generateAuthParams(rand)
+func (sigma *authMessage) auth(rand io.Reader, ourPub, theirPub, theirPubEcdh ed448.Point, ourSec ed448.Scalar, message []byte) error {
+	proofs, err := proveOr(rand, ringStatements(ourPub, theirPub, theirPubEcdh), 0,
+		[]ed448.Scalar{ourSec}, ringChallenge(ourPub, theirPub, theirPubEcdh, message))
 	if err != nil {
 		return err
 	}
 
-	pt1 := ed448.PointScalarMul(ed448.BasePoint, t1)
-	pt2 := ed448.PointDoubleScalarMul(ed448.BasePoint, theirPub, sigma.r2, sigma.c2)
-	pt3 := ed448.PointDoubleScalarMul(ed448.BasePoint, theirPubEcdh, sigma.r3, sigma.c3)
-	c := appendAndHash(ed448.BasePoint, ed448.ScalarQ, ourPub, theirPub, theirPubEcdh, pt1, pt2, pt3, message)
-	sigma.c1, sigma.r1 = ed448.NewScalar(), ed448.NewScalar()
-	sigma.c1.Sub(c, sigma.c2)
-	sigma.c1.Sub(sigma.c1, sigma.c3)
-	sigma.r1.Mul(sigma.c1, ourSec)
-	sigma.r1.Sub(t1, sigma.r1)
+	sigma.c1, sigma.r1 = proofs[0].c, proofs[0].d[0]
+	sigma.c2, sigma.r2 = proofs[1].c, proofs[1].d[0]
+	sigma.c3, sigma.r3 = proofs[2].c, proofs[2].d[0]
 	return nil
 }
 
 func (sigma *authMessage) verify(theirPub, ourPub, ourPubEcdh ed448.Point, message []byte) bool {
-	pt1 := ed448.PointDoubleScalarMul(ed448.BasePoint, theirPub, sigma.r1, sigma.c1)
-	pt2 := ed448.PointDoubleScalarMul(ed448.BasePoint, ourPub, sigma.r2, sigma.c2)
-	pt3 := ed448.PointDoubleScalarMul(ed448.BasePoint, ourPubEcdh, sigma.r3, sigma.c3)
-	c := appendAndHash(ed448.BasePoint, ed448.ScalarQ, theirPub, ourPub, ourPubEcdh, pt1, pt2, pt3, message)
-	out := ed448.NewScalar()
-	out.Add(sigma.c1, sigma.c2)
-	out.Add(out, sigma.c3)
-	return c.Equals(out)
+	proofs := []*sigmaProof{
+		newSigmaProof(sigma.c1, sigma.r1),
+		newSigmaProof(sigma.c2, sigma.r2),
+		newSigmaProof(sigma.c3, sigma.r3),
+	}
+
+	return verifyOr(ringStatements(theirPub, ourPub, ourPubEcdh), proofs,
+		ringChallenge(theirPub, ourPub, ourPubEcdh, message))
+}
+
+func (sigma *authMessage) serialize() []byte {
+	var out []byte
+	for _, s := range []ed448.Scalar{sigma.c1, sigma.r1, sigma.c2, sigma.r2, sigma.c3, sigma.r3} {
+		out = appendScalar(out, s)
+	}
+
+	return out
+}
+
+func (sigma *authMessage) deserialize(bs []byte) ([]byte, error) {
+	if len(bs) < authMessageBytes {
+		return nil, errInvalidLength
+	}
+
+	var err error
+	cursor := bs
+	for _, s := range []*ed448.Scalar{&sigma.c1, &sigma.r1, &sigma.c2, &sigma.r2, &sigma.c3, &sigma.r3} {
+		cursor, *s, err = extractScalar(cursor)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return cursor, nil
 }
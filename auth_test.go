@@ -6,21 +6,21 @@ import (
 	. "gopkg.in/check.v1"
 )
 
-func (s *OTR4Suite) Test_GenerateAuthParams(c *C) {
-	sigma := new(authMessage)
+func (s *OTR4Suite) Test_SimulateRingProofs(c *C) {
+	statements := ringStatements(testPubA.h, testPubB.h, testPubC)
 
 	r := make([]byte, 55)
-	err := sigma.generateAuthParams(fixedRand(r))
+	_, err := simulateSigmaProofs(fixedRand(r), statements, 0)
 
 	c.Assert(err, ErrorMatches, ".*cannot source enough entropy")
 
 	r = make([]byte, 111)
-	err = sigma.generateAuthParams(fixedRand(r))
+	_, err = simulateSigmaProofs(fixedRand(r), statements, 0)
 
 	c.Assert(err, ErrorMatches, ".*cannot source enough entropy")
 
 	r = make([]byte, 117)
-	err = sigma.generateAuthParams(fixedRand(r))
+	_, err = simulateSigmaProofs(fixedRand(r), statements, 0)
 
 	c.Assert(err, ErrorMatches, ".*cannot source enough entropy")
 }
@@ -79,3 +79,35 @@ func (s *OTR4Suite) Test_VerifyAndAuth(c *C) {
 	ver = testSigma.verify(pubA.h, pubB.h, testPubC, message)
 	c.Assert(ver, Equals, false)
 }
+
+func (s *OTR4Suite) Test_SerializeAuthMessage(c *C) {
+	ser := testSigma.serialize()
+
+	c.Assert(ser, HasLen, 336)
+	c.Assert(ser[:56], DeepEquals, testSigma.c1.Encode())
+	c.Assert(ser[280:], DeepEquals, testSigma.r3.Encode())
+
+	sigma := &authMessage{}
+	rest, err := sigma.deserialize(append(ser, 0x01, 0x02))
+
+	c.Assert(err, IsNil)
+	c.Assert(rest, DeepEquals, []byte{0x01, 0x02})
+	c.Assert(sigma, DeepEquals, testSigma)
+}
+
+func (s *OTR4Suite) Test_DeserializeAuthMessageErrors(c *C) {
+	sigma := &authMessage{}
+
+	_, err := sigma.deserialize(testSigma.serialize()[:335])
+
+	c.Assert(err, ErrorMatches, ".* invalid length")
+
+	ser := testSigma.serialize()
+	for i := 56; i < 112; i++ {
+		ser[i] = 0xff
+	}
+
+	_, err = sigma.deserialize(ser)
+
+	c.Assert(err, ErrorMatches, ".* non-canonical scalar")
+}
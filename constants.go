@@ -19,6 +19,26 @@ const (
 	mask          = 0x80
 )
 
+// usage IDs for the KDF, used to domain separate every derived value
+const (
+	usageProfile       = 0x01
+	usageThirdBraceKey = 0x02
+	usageBraceKey      = 0x03
+	usageSharedSecret  = 0x04
+	usageSSID          = 0x05
+	usageRootKey       = 0x06
+	usageChainKey      = 0x07
+	usageNextChainKey  = 0x08
+	usageMessageKey    = 0x09
+	usageEncryptionKey = 0x0A
+	usageMACKey        = 0x0B
+	usageAuthenticator = 0x0C
+	usageFingerprint   = 0x0D
+	usageRatchetSecret = 0x0E
+)
+
+var kdfDomain = []byte("OTRv4")
+
 var (
 	g2 = ed448.NewPoint(
 		[16]uint32{
@@ -1,7 +1,362 @@
 package otr4
 
-import "io"
+import (
+	"bytes"
+	"crypto/dsa"
+	"encoding/base64"
+	"io"
+	"time"
+)
 
-type conversation struct {
-	random io.Reader
+const (
+	otrVersion = 4
+)
+
+var (
+	msgPrefix = []byte("?OTR:")
+	msgSuffix = []byte(".")
+)
+
+type msgState int
+
+const (
+	plainText msgState = iota
+	encrypted
+	finished
+)
+
+// Conversation represents an OTRv4 conversation with a single remote peer.
+// The host application feeds every message it gets from the network to
+// Receive and passes every message the user types through Send; the
+// conversation takes care of the session state in between.
+type Conversation struct {
+	// MaxSkippedKeys bounds how many message keys are kept around for
+	// messages that were skipped and may still arrive out of order. If
+	// zero, a default of 1000 is used.
+	MaxSkippedKeys int
+
+	// MaxRevealedMACKeys bounds how many of the MAC keys revealed in the
+	// session are kept for RevealedMACKeys; the oldest are dropped first.
+	// If zero, a default of 100 is used.
+	MaxRevealedMACKeys int
+
+	// FragmentSize is the maximum size of the messages the transport can
+	// carry. Longer messages are split into fragments. If zero, messages
+	// are never split.
+	FragmentSize int
+
+	// ProfileClockSkew is how long after its expiration the profile of
+	// the peer is still accepted, to allow for clocks that are not in
+	// sync. If zero, a default of 5 minutes is used.
+	ProfileClockSkew time.Duration
+
+	// Policy controls how OTR is offered and negotiated. If zero, version
+	// 4 is allowed and a whitespace tag starts the DAKE.
+	Policy Policy
+
+	// Identity is our long-term key. If nil, a new key is generated for
+	// this conversation only, so the peer cannot recognize us later.
+	Identity *Identity
+
+	// TransitionKey is our OTRv3 DSA key, if we have one. Our client
+	// profile is then signed with it too, so peers who know our OTRv3
+	// fingerprint can tell the new key is ours with VerifyTransition.
+	TransitionKey *dsa.PrivateKey
+
+	// InstanceTags keeps our instance tag across restarts. When it has
+	// none, or is nil, the tag saved with the Identity is used, if any.
+	// Otherwise a new tag is generated, and saved to InstanceTags.
+	InstanceTags InstanceTagStore
+
+	// SMPEvents is told how SMP runs go. It can be nil.
+	SMPEvents SMPEventHandler
+
+	// Trust is where the fingerprint of the peer is marked as verified
+	// when SMP succeeds. If it fails to do so,
+	// SMPEventVerificationNotSaved is reported instead of
+	// SMPEventSucceeded.
+	Trust TrustStore
+
+	random  io.Reader
+	keys    *keyPair
+	profile *userProfile
+
+	instanceTag      uint32
+	theirInstanceTag uint32
+
+	msgState msgState
+	ake      *ake
+	ratchet  *ratchet
+	macKeys  macKeyStore
+
+	tlvHandlers map[uint16]TLVHandler
+	// replyTLVs are sent back in a data message once the received
+	// message has been handled
+	replyTLVs []TLV
+	fragments reassembler
+
+	smp smp
+
+	whitespaceTagSent bool
+
+	theirProfile *userProfile
+	theirPub     *publicKey
+	ssid         []byte
+}
+
+// IsEncrypted returns true if messages sent through this conversation will
+// be encrypted.
+func (c *Conversation) IsEncrypted() bool {
+	return c.msgState == encrypted
+}
+
+// Send takes a plaintext message from the user and returns the messages
+// that should be sent to the peer over the network.
+func (c *Conversation) Send(plaintext []byte) ([][]byte, error) {
+	return c.SendWithTLVs(plaintext, nil)
+}
+
+// SendWithTLVs is like Send, but also attaches the given TLVs to the
+// message. TLVs can only be sent in an encrypted conversation.
+func (c *Conversation) SendWithTLVs(plaintext []byte, tlvs []TLV) ([][]byte, error) {
+	switch c.msgState {
+	case plainText:
+		if len(tlvs) > 0 {
+			return nil, errUnexpectedState
+		}
+
+		if c.policy().has(SendWhitespaceTag) && !c.whitespaceTagSent {
+			c.whitespaceTagSent = true
+			plaintext = append(append([]byte{}, plaintext...), whitespaceTag(c.policy())...)
+		}
+
+		return [][]byte{plaintext}, nil
+	case encrypted:
+		p, err := joinMessage(plaintext, tlvs)
+		if err != nil {
+			return nil, err
+		}
+
+		msg, err := c.createDataMessage(p, 0)
+		if err != nil {
+			return nil, err
+		}
+
+		return c.toSend(msg)
+	case finished:
+		return nil, errConversationFinished
+	}
+
+	return nil, errUnexpectedState
+}
+
+// End terminates the private conversation. It returns the messages that
+// should be sent to let the peer know, which also reveal all the MAC keys
+// not revealed yet.
+func (c *Conversation) End() ([][]byte, error) {
+	switch c.msgState {
+	case plainText:
+		return nil, nil
+	case finished:
+		c.msgState = plainText
+		return nil, nil
+	}
+
+	c.smp.abort()
+
+	for _, mk := range c.ratchet.skipped.drain() {
+		c.macKeys.used(macKey(mk))
+	}
+
+	disconnected, _ := joinMessage(nil, []TLV{{Type: tlvTypeDisconnected}})
+	msg, err := c.createDataMessage(disconnected, flagIgnoreUnreadable)
+	if err != nil {
+		return nil, err
+	}
+
+	c.ratchet = nil
+	c.msgState = plainText
+
+	return c.toSend(msg)
+}
+
+// Receive takes a message received from the network. It returns the
+// plaintext to be shown to the user, if any, and the messages that should
+// be sent back to the peer. The plaintext can be returned along with an
+// error, when the message was decrypted but one of its TLVs failed.
+func (c *Conversation) Receive(wire []byte) (plaintext []byte, toSend [][]byte, err error) {
+	if bytes.HasPrefix(wire, fragmentPrefix) {
+		wire, err = c.receiveFragment(wire)
+		if err != nil || wire == nil {
+			return nil, nil, err
+		}
+	}
+
+	var msg, out, reply []byte
+	if bytes.HasPrefix(wire, msgPrefix) {
+		msg, err = decodeMessage(wire)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		out, reply, err = c.receiveDecoded(msg)
+	} else {
+		out, reply, err = c.receivePlaintext(wire)
+	}
+
+	if err != nil {
+		return out, nil, err
+	}
+
+	if reply != nil {
+		toSend, err = c.toSend(reply)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	return out, toSend, nil
+}
+
+func (c *Conversation) receiveDecoded(msg []byte) ([]byte, []byte, error) {
+	body, h, err := parseMessageHeader(msg)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	accept, err := c.acceptInstanceTags(h)
+	if !accept {
+		return nil, nil, err
+	}
+
+	switch h.typ {
+	case identityMsgType:
+		reply, err := c.receiveIdentityMessage(h, body)
+		return nil, reply, err
+	case authRMsgType:
+		reply, err := c.receiveAuthRMessage(h, body)
+		return nil, reply, err
+	case authIMsgType:
+		return nil, nil, c.receiveAuthIMessage(body)
+	case dataMsgType:
+		plaintext, err := c.receiveDataMessage(msg, body)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		out, tlvs, err := splitMessage(plaintext)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		if len(out) == 0 {
+			out = nil
+		}
+
+		// the message was decrypted, so it is returned even if a TLV
+		// could not be handled
+		if err := c.handleTLVs(tlvs); err != nil {
+			c.replyTLVs = nil
+			return out, nil, err
+		}
+
+		reply, err := c.sendReplyTLVs()
+		return out, reply, err
+	default:
+		return nil, nil, errUnsupportedMessage
+	}
+}
+
+// sendTLVs sends the TLVs in a data message without a message for the user
+func (c *Conversation) sendTLVs(tlvs ...TLV) ([][]byte, error) {
+	if c.msgState != encrypted {
+		return nil, errUnexpectedState
+	}
+
+	msg, err := c.createTLVMessage(tlvs)
+	if err != nil {
+		return nil, err
+	}
+
+	return c.toSend(msg)
+}
+
+// sendReplyTLVs returns the data message carrying the TLVs queued while
+// handling a received message. With no TLVs queued, it returns a heartbeat
+// message without any when too many MAC keys are waiting to be revealed.
+func (c *Conversation) sendReplyTLVs() ([]byte, error) {
+	tlvs := c.replyTLVs
+	c.replyTLVs = nil
+
+	if c.msgState != encrypted {
+		return nil, nil
+	}
+
+	if len(tlvs) == 0 && !c.macKeys.needsHeartbeat() {
+		return nil, nil
+	}
+
+	return c.createTLVMessage(tlvs)
+}
+
+func (c *Conversation) createTLVMessage(tlvs []TLV) ([]byte, error) {
+	p, err := joinMessage(nil, tlvs)
+	if err != nil {
+		return nil, err
+	}
+
+	return c.createDataMessage(p, flagIgnoreUnreadable)
+}
+
+func (c *Conversation) ourKeys() (*keyPair, error) {
+	if c.keys != nil {
+		return c.keys, nil
+	}
+
+	if c.Identity != nil {
+		c.keys = c.Identity.keys
+		return c.keys, nil
+	}
+
+	pub, priv, err := generateKeys(c.rand())
+	if err != nil {
+		return nil, err
+	}
+
+	c.keys = &keyPair{pub: *pub, priv: *priv}
+	return c.keys, nil
+}
+
+func (c *Conversation) wrapMessage(typ byte, body []byte) ([]byte, error) {
+	tag, err := c.ourInstanceTag()
+	if err != nil {
+		return nil, err
+	}
+
+	h := messageHeader{typ: typ, sender: tag, receiver: c.theirInstanceTag}
+	return append(h.serialize(), body...), nil
+}
+
+func encodeMessage(msg []byte) []byte {
+	out := make([]byte, len(msgPrefix)+base64.StdEncoding.EncodedLen(len(msg))+len(msgSuffix))
+	copy(out, msgPrefix)
+	base64.StdEncoding.Encode(out[len(msgPrefix):], msg)
+	copy(out[len(out)-len(msgSuffix):], msgSuffix)
+
+	return out
+}
+
+func decodeMessage(wire []byte) ([]byte, error) {
+	if !bytes.HasPrefix(wire, msgPrefix) || !bytes.HasSuffix(wire, msgSuffix) {
+		return nil, errInvalidOTRMessage
+	}
+
+	encoded := wire[len(msgPrefix) : len(wire)-len(msgSuffix)]
+	msg := make([]byte, base64.StdEncoding.DecodedLen(len(encoded)))
+	l, err := base64.StdEncoding.Decode(msg, encoded)
+	if err != nil {
+		return nil, errInvalidOTRMessage
+	}
+
+	return msg[:l], nil
 }
@@ -11,3 +11,68 @@ func Test(t *testing.T) { TestingT(t) }
 type OTR4Suite struct{}
 
 var _ = Suite(&OTR4Suite{})
+
+func (s *OTR4Suite) Test_SendInPlaintextReturnsTheMessage(c *C) {
+	con := &Conversation{}
+	msg := []byte("hello")
+
+	toSend, err := con.Send(msg)
+
+	c.Assert(err, IsNil)
+	c.Assert(toSend, DeepEquals, [][]byte{msg})
+	c.Assert(con.IsEncrypted(), Equals, false)
+}
+
+func (s *OTR4Suite) Test_SendFailsWhenFinished(c *C) {
+	con := &Conversation{msgState: finished}
+
+	toSend, err := con.Send([]byte("hello"))
+
+	c.Assert(toSend, IsNil)
+	c.Assert(err, ErrorMatches, ".* conversation has been finished by the peer")
+}
+
+func (s *OTR4Suite) Test_ReceivePlaintext(c *C) {
+	con := &Conversation{}
+	msg := []byte("hello")
+
+	plain, toSend, err := con.Receive(msg)
+
+	c.Assert(err, IsNil)
+	c.Assert(plain, DeepEquals, msg)
+	c.Assert(toSend, IsNil)
+}
+
+func (s *OTR4Suite) Test_ReceiveRejectsInvalidMessages(c *C) {
+	con := &Conversation{}
+
+	_, _, err := con.Receive([]byte("?OTR:AAQ"))
+	c.Assert(err, ErrorMatches, ".* invalid OTR message")
+
+	_, _, err = con.Receive([]byte("?OTR:!!!!."))
+	c.Assert(err, ErrorMatches, ".* invalid OTR message")
+
+	_, _, err = con.Receive(encodeMessage([]byte{0x00, 0x03, 0x01}))
+	c.Assert(err, ErrorMatches, ".* no valid version agreement could be found")
+
+	_, _, err = con.Receive(encodeMessage([]byte{0x00, 0x04}))
+	c.Assert(err, ErrorMatches, ".* invalid length")
+
+	_, _, err = con.Receive(encodeMessage([]byte{
+		0x00, 0x04, 0xff, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00,
+	}))
+	c.Assert(err, ErrorMatches, ".* unsupported message type")
+}
+
+func (s *OTR4Suite) Test_EncodeAndDecodeMessage(c *C) {
+	msg := []byte{0x00, 0x04, 0x08, 0xaa, 0xbb}
+
+	wire := encodeMessage(msg)
+
+	c.Assert(wire, DeepEquals, []byte("?OTR:AAQIqrs=."))
+
+	dec, err := decodeMessage(wire)
+
+	c.Assert(err, IsNil)
+	c.Assert(dec, DeepEquals, msg)
+}
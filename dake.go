@@ -0,0 +1,376 @@
+package otr4
+
+import (
+	"bytes"
+	"math/big"
+
+	"github.com/twstrike/ed448"
+)
+
+const (
+	identityMsgType byte = 0x08
+	authRMsgType    byte = 0x91
+	authIMsgType    byte = 0x88
+)
+
+const (
+	authRTranscript byte = 0x00
+	authITranscript byte = 0x01
+)
+
+type dakeState int
+
+const (
+	dakeNone dakeState = iota
+	dakeWaitingAuthR
+	dakeWaitingAuthI
+)
+
+// ake holds the values of an interactive DAKE in progress. Bob is the
+// party sending the Identity message, Alice the one answering with Auth-R.
+type ake struct {
+	state dakeState
+
+	ourECDH *ecdhKeyPair
+	ourDH   *dhKeyPair
+
+	theirProfile *userProfile
+	theirECDH    ed448.Point
+	theirDH      *big.Int
+
+	// weAreBob is set when we sent the Identity message
+	weAreBob bool
+}
+
+type messageHeader struct {
+	typ      byte
+	sender   uint32
+	receiver uint32
+}
+
+func (h messageHeader) serialize() []byte {
+	out := appendWord16(nil, otrVersion)
+	out = append(out, h.typ)
+	out = appendWord32(out, h.sender)
+	return appendWord32(out, h.receiver)
+}
+
+func parseMessageHeader(msg []byte) ([]byte, messageHeader, error) {
+	h := messageHeader{}
+
+	cursor, version, ok := extractWord16(msg)
+	if !ok || version != otrVersion {
+		return nil, h, errInvalidVersion
+	}
+
+	if len(cursor) < 1 {
+		return nil, h, errInvalidLength
+	}
+	h.typ = cursor[0]
+	cursor = cursor[1:]
+
+	cursor, h.sender, ok = extractWord32(cursor)
+	if !ok {
+		return nil, h, errInvalidLength
+	}
+
+	cursor, h.receiver, ok = extractWord32(cursor)
+	if !ok {
+		return nil, h, errInvalidLength
+	}
+
+	return cursor, h, nil
+}
+
+type identityMessage struct {
+	profile *userProfile
+	y       ed448.Point
+	b       *big.Int
+}
+
+func (m *identityMessage) serialize() []byte {
+	out := m.profile.serialize()
+	out = append(out, m.y.Encode()...)
+	return appendMPI(out, m.b)
+}
+
+func (m *identityMessage) deserialize(msg []byte) error {
+	var err error
+
+	m.profile, msg, err = deserializeProfile(msg)
+	if err != nil {
+		return err
+	}
+
+	m.y, msg, err = extractECDHPoint(msg)
+	if err != nil {
+		return err
+	}
+
+	m.b, _, err = extractDHValue(msg)
+	return err
+}
+
+type authRMessage struct {
+	profile *userProfile
+	x       ed448.Point
+	a       *big.Int
+	sigma   *authMessage
+}
+
+func (m *authRMessage) serialize() []byte {
+	out := m.profile.serialize()
+	out = append(out, m.x.Encode()...)
+	out = appendMPI(out, m.a)
+	return append(out, m.sigma.serialize()...)
+}
+
+func (m *authRMessage) deserialize(msg []byte) error {
+	var err error
+
+	m.profile, msg, err = deserializeProfile(msg)
+	if err != nil {
+		return err
+	}
+
+	m.x, msg, err = extractECDHPoint(msg)
+	if err != nil {
+		return err
+	}
+
+	m.a, msg, err = extractDHValue(msg)
+	if err != nil {
+		return err
+	}
+
+	m.sigma = &authMessage{}
+	_, err = m.sigma.deserialize(msg)
+	return err
+}
+
+type authIMessage struct {
+	sigma *authMessage
+}
+
+func (m *authIMessage) serialize() []byte {
+	return m.sigma.serialize()
+}
+
+func (m *authIMessage) deserialize(msg []byte) error {
+	m.sigma = &authMessage{}
+	_, err := m.sigma.deserialize(msg)
+	return err
+}
+
+func extractECDHPoint(msg []byte) (ed448.Point, []byte, error) {
+	p, cursor, err := extractPoint(msg, 0)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if !p.IsOnCurve() || isLowOrderPoint(p) {
+		return nil, nil, errInvalidPoint
+	}
+
+	return p, msg[cursor:], nil
+}
+
+func extractDHValue(msg []byte) (*big.Int, []byte, error) {
+	cursor, v, ok := extractMPI(msg)
+	if !ok {
+		return nil, nil, errInvalidLength
+	}
+
+	if !isGroupElement(v) {
+		return nil, nil, errInvalidDHValue
+	}
+
+	return v, cursor, nil
+}
+
+// dakeTranscript builds the message t signed by both parties. Bob is the
+// party who sent the Identity message.
+func dakeTranscript(prefix byte, bobProfile, aliceProfile *userProfile, y, x ed448.Point, b, a *big.Int) []byte {
+	out := []byte{prefix}
+	out = append(out, kdf(usageProfile, 64, bobProfile.serialize())...)
+	out = append(out, kdf(usageProfile, 64, aliceProfile.serialize())...)
+	out = append(out, y.Encode()...)
+	out = append(out, x.Encode()...)
+	out = appendMPI(out, b)
+	return appendMPI(out, a)
+}
+
+func (c *Conversation) startDAKE() ([]byte, error) {
+	profile, err := c.ourProfile()
+	if err != nil {
+		return nil, err
+	}
+
+	ourECDH, err := generateECDHKeyPair(c.rand())
+	if err != nil {
+		return nil, err
+	}
+
+	ourDH, err := generateDHKeyPair(c.rand())
+	if err != nil {
+		return nil, err
+	}
+
+	m := &identityMessage{profile: profile, y: ourECDH.pub, b: ourDH.pub}
+	msg, err := c.wrapMessage(identityMsgType, m.serialize())
+	if err != nil {
+		return nil, err
+	}
+
+	c.ake = &ake{
+		state:    dakeWaitingAuthR,
+		ourECDH:  ourECDH,
+		ourDH:    ourDH,
+		weAreBob: true,
+	}
+
+	return msg, nil
+}
+
+func (c *Conversation) receiveIdentityMessage(h messageHeader, msg []byte) ([]byte, error) {
+	m := &identityMessage{}
+	if err := m.deserialize(msg); err != nil {
+		return nil, err
+	}
+
+	if err := c.validateTheirProfile(m.profile, h.sender); err != nil {
+		return nil, err
+	}
+
+	if c.ake != nil && c.ake.state == dakeWaitingAuthR {
+		// both sides started the DAKE: the one with the bigger ECDH
+		// value keeps going as Bob and ignores the other Identity
+		// message, the other one answers as Alice
+		if bytes.Compare(c.ake.ourECDH.pub.Encode(), m.y.Encode()) > 0 {
+			return nil, nil
+		}
+	}
+
+	profile, err := c.ourProfile()
+	if err != nil {
+		return nil, err
+	}
+
+	ourECDH, err := generateECDHKeyPair(c.rand())
+	if err != nil {
+		return nil, err
+	}
+
+	ourDH, err := generateDHKeyPair(c.rand())
+	if err != nil {
+		return nil, err
+	}
+
+	c.theirInstanceTag = h.sender
+	c.ake = &ake{
+		state:        dakeWaitingAuthI,
+		ourECDH:      ourECDH,
+		ourDH:        ourDH,
+		theirProfile: m.profile,
+		theirECDH:    m.y,
+		theirDH:      m.b,
+	}
+
+	t := dakeTranscript(authRTranscript, m.profile, profile, m.y, ourECDH.pub, m.b, ourDH.pub)
+	sigma := &authMessage{}
+	err = sigma.auth(c.rand(), c.keys.pub.h, m.profile.pub.h, m.y, c.keys.priv.r, t)
+	if err != nil {
+		return nil, err
+	}
+
+	authR := &authRMessage{profile: profile, x: ourECDH.pub, a: ourDH.pub, sigma: sigma}
+	return c.wrapMessage(authRMsgType, authR.serialize())
+}
+
+func (c *Conversation) receiveAuthRMessage(h messageHeader, msg []byte) ([]byte, error) {
+	if c.ake == nil || c.ake.state != dakeWaitingAuthR {
+		return nil, nil
+	}
+
+	m := &authRMessage{}
+	if err := m.deserialize(msg); err != nil {
+		return nil, err
+	}
+
+	if err := c.validateTheirProfile(m.profile, h.sender); err != nil {
+		return nil, err
+	}
+
+	profile, err := c.ourProfile()
+	if err != nil {
+		return nil, err
+	}
+
+	t := dakeTranscript(authRTranscript, profile, m.profile, c.ake.ourECDH.pub, m.x, c.ake.ourDH.pub, m.a)
+	if !m.sigma.verify(m.profile.pub.h, c.keys.pub.h, c.ake.ourECDH.pub, t) {
+		return nil, errInvalidAuth
+	}
+
+	c.theirInstanceTag = h.sender
+	c.ake.theirProfile = m.profile
+	c.ake.theirECDH = m.x
+	c.ake.theirDH = m.a
+
+	t = dakeTranscript(authITranscript, profile, m.profile, c.ake.ourECDH.pub, m.x, c.ake.ourDH.pub, m.a)
+	sigma := &authMessage{}
+	err = sigma.auth(c.rand(), c.keys.pub.h, m.profile.pub.h, m.x, c.keys.priv.r, t)
+	if err != nil {
+		return nil, err
+	}
+
+	authI := &authIMessage{sigma: sigma}
+	reply, err := c.wrapMessage(authIMsgType, authI.serialize())
+	if err != nil {
+		return nil, err
+	}
+
+	c.completeDAKE()
+	return reply, nil
+}
+
+func (c *Conversation) receiveAuthIMessage(msg []byte) error {
+	if c.ake == nil || c.ake.state != dakeWaitingAuthI {
+		return nil
+	}
+
+	m := &authIMessage{}
+	if err := m.deserialize(msg); err != nil {
+		return err
+	}
+
+	profile, err := c.ourProfile()
+	if err != nil {
+		return err
+	}
+
+	theirs := c.ake.theirProfile
+	t := dakeTranscript(authITranscript, theirs, profile, c.ake.theirECDH, c.ake.ourECDH.pub, c.ake.theirDH, c.ake.ourDH.pub)
+	if !m.sigma.verify(theirs.pub.h, c.keys.pub.h, c.ake.ourECDH.pub, t) {
+		return errInvalidAuth
+	}
+
+	c.completeDAKE()
+	return nil
+}
+
+// completeDAKE derives the shared secret and the session id from the
+// ephemeral values exchanged during the DAKE, and uses them to initialize
+// the double ratchet
+func (c *Conversation) completeDAKE() {
+	k := ecdhSecret(c.ake.ourECDH.priv, c.ake.theirECDH)
+	braceKey := kdf(usageThirdBraceKey, 32, dhSecret(c.ake.ourDH.priv, c.ake.theirDH))
+	sharedSecret := kdf(usageSharedSecret, 64, k, braceKey)
+
+	c.ssid = kdf(usageSSID, 8, sharedSecret)
+	c.ratchet = newRatchet(c.ake, sharedSecret, braceKey, c.MaxSkippedKeys)
+	c.macKeys = macKeyStore{max: c.MaxRevealedMACKeys}
+	c.theirProfile = c.ake.theirProfile
+	c.theirPub = c.ake.theirProfile.pub
+	c.ake = nil
+	c.msgState = encrypted
+}
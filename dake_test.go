@@ -0,0 +1,256 @@
+package otr4
+
+import (
+	"crypto/rand"
+	"time"
+
+	"github.com/twstrike/ed448"
+	. "gopkg.in/check.v1"
+)
+
+func (s *OTR4Suite) Test_MessageHeaderSerialization(c *C) {
+	h := messageHeader{typ: identityMsgType, sender: 0x101, receiver: 0x1ff}
+
+	ser := h.serialize()
+
+	c.Assert(ser, DeepEquals, []byte{
+		0x00, 0x04, 0x08, 0x00, 0x00, 0x01, 0x01, 0x00, 0x00, 0x01, 0xff,
+	})
+
+	rest, h2, err := parseMessageHeader(append(ser, 0xaa))
+
+	c.Assert(err, IsNil)
+	c.Assert(h2, DeepEquals, h)
+	c.Assert(rest, DeepEquals, []byte{0xaa})
+
+	_, _, err = parseMessageHeader(ser[:5])
+
+	c.Assert(err, ErrorMatches, ".* invalid length")
+}
+
+func (s *OTR4Suite) Test_IdentityMessageSerialization(c *C) {
+	profile, err := newProfile("4", 0x100, generateTestKeyPair(c))
+	c.Assert(err, IsNil)
+	ecdh, _ := generateECDHKeyPair(rand.Reader)
+	dh, _ := generateDHKeyPair(rand.Reader)
+	m := &identityMessage{profile: profile, y: ecdh.pub, b: dh.pub}
+
+	m2 := &identityMessage{}
+	err = m2.deserialize(m.serialize())
+
+	c.Assert(err, IsNil)
+	c.Assert(m2.profile.serialize(), DeepEquals, profile.serialize())
+	c.Assert(m2.y.Equals(ecdh.pub), Equals, true)
+	c.Assert(m2.b, DeepEquals, dh.pub)
+
+	err = m2.deserialize(m.serialize()[:70])
+
+	c.Assert(err, ErrorMatches, ".* invalid length")
+}
+
+func (s *OTR4Suite) Test_IdentityMessageRejectsInvalidPoints(c *C) {
+	profile, err := newProfile("4", 0x100, generateTestKeyPair(c))
+	c.Assert(err, IsNil)
+	dh, _ := generateDHKeyPair(rand.Reader)
+
+	undecodable := make([]byte, fieldBytes)
+	for i := range undecodable {
+		undecodable[i] = 0xff
+	}
+
+	for _, y := range [][]byte{undecodable, ed448.NewPointFromBytes().Encode()} {
+		msg := append(profile.serialize(), y...)
+		msg = appendMPI(msg, dh.pub)
+
+		m := &identityMessage{}
+		err = m.deserialize(msg)
+
+		c.Assert(err, NotNil)
+		c.Assert(m.y, IsNil)
+	}
+}
+
+func (s *OTR4Suite) Test_DAKEEstablishesASession(c *C) {
+	bob := &Conversation{}
+	alice := &Conversation{}
+
+	identity, err := bob.startDAKE()
+	c.Assert(err, IsNil)
+
+	_, toSend, err := alice.Receive(encodeMessage(identity))
+	c.Assert(err, IsNil)
+	c.Assert(toSend, HasLen, 1)
+	c.Assert(alice.ake.state, Equals, dakeWaitingAuthI)
+
+	_, toSend, err = bob.Receive(toSend[0])
+	c.Assert(err, IsNil)
+	c.Assert(toSend, HasLen, 1)
+	c.Assert(bob.IsEncrypted(), Equals, true)
+
+	_, toSend, err = alice.Receive(toSend[0])
+	c.Assert(err, IsNil)
+	c.Assert(toSend, IsNil)
+	c.Assert(alice.IsEncrypted(), Equals, true)
+
+	c.Assert(alice.ratchet.rootKey, HasLen, 64)
+	c.Assert(alice.ratchet.rootKey, DeepEquals, bob.ratchet.rootKey)
+	c.Assert(alice.ratchet.receivingChainKey, DeepEquals, bob.ratchet.sendingChainKey)
+	c.Assert(alice.theirPub.h.Equals(bob.keys.pub.h), Equals, true)
+	c.Assert(alice.theirProfile.serialize(), DeepEquals, bob.profile.serialize())
+	c.Assert(bob.theirProfile.serialize(), DeepEquals, alice.profile.serialize())
+	c.Assert(alice.ssid, HasLen, 8)
+	c.Assert(alice.ssid, DeepEquals, bob.ssid)
+	c.Assert(alice.theirInstanceTag, Equals, bob.instanceTag)
+	c.Assert(bob.theirInstanceTag, Equals, alice.instanceTag)
+}
+
+func (s *OTR4Suite) Test_DAKERejectsAForgedAuthR(c *C) {
+	bob := &Conversation{}
+	alice := &Conversation{}
+	mallory := &Conversation{}
+
+	identity, _ := bob.startDAKE()
+	_, authR, _ := alice.receiveDecoded(identity)
+
+	// mallory replaces alice's profile with a valid one of her own
+	body, h, _ := parseMessageHeader(authR)
+	mallory.instanceTag = h.sender
+	malloryProfile, err := mallory.ourProfile()
+	c.Assert(err, IsNil)
+	m := &authRMessage{}
+	c.Assert(m.deserialize(body), IsNil)
+	m.profile = malloryProfile
+	forged := append(h.serialize(), m.serialize()...)
+
+	_, _, err = bob.receiveDecoded(forged)
+
+	c.Assert(err, ErrorMatches, ".* DAKE authentication failed")
+	c.Assert(bob.IsEncrypted(), Equals, false)
+}
+
+func (s *OTR4Suite) Test_DAKERejectsAnIdentityMessageWithAnInvalidProfile(c *C) {
+	bob := &Conversation{}
+	alice := &Conversation{}
+
+	identity, err := bob.startDAKE()
+	c.Assert(err, IsNil)
+
+	body, h, _ := parseMessageHeader(identity)
+	m := &identityMessage{}
+	c.Assert(m.deserialize(body), IsNil)
+	m.profile.expiration++
+	tampered := append(h.serialize(), m.serialize()...)
+
+	_, reply, err := alice.receiveDecoded(tampered)
+
+	c.Assert(err, Equals, ErrInvalidProfileSignature)
+	c.Assert(reply, IsNil)
+	c.Assert(alice.ake, IsNil)
+}
+
+// resignedIdentity changes the profile in an Identity message of bob, and
+// signs it again
+func resignedIdentity(c *C, bob *Conversation, change func(*userProfile)) []byte {
+	identity, err := bob.startDAKE()
+	c.Assert(err, IsNil)
+
+	body, h, err := parseMessageHeader(identity)
+	c.Assert(err, IsNil)
+	m := &identityMessage{}
+	c.Assert(m.deserialize(body), IsNil)
+
+	change(m.profile)
+	c.Assert(m.profile.sign(bob.keys), IsNil)
+
+	return encodeMessage(append(h.serialize(), m.serialize()...))
+}
+
+func (s *OTR4Suite) Test_DAKERejectsAProfileOfAnotherInstance(c *C) {
+	identity := resignedIdentity(c, &Conversation{}, func(p *userProfile) {
+		p.instanceTag++
+	})
+
+	_, toSend, err := (&Conversation{}).Receive(identity)
+
+	c.Assert(err, Equals, ErrProfileInstanceTagMismatch)
+	c.Assert(err, FitsTypeOf, ProfileError{})
+	c.Assert(toSend, IsNil)
+}
+
+func (s *OTR4Suite) Test_DAKEAcceptsExpiredProfilesWithinTheClockSkew(c *C) {
+	identity := resignedIdentity(c, &Conversation{}, func(p *userProfile) {
+		p.expiration = time.Now().Add(-time.Minute).Unix()
+	})
+
+	_, toSend, err := (&Conversation{}).Receive(identity)
+	c.Assert(err, IsNil)
+	c.Assert(toSend, HasLen, 1)
+
+	_, toSend, err = (&Conversation{ProfileClockSkew: 30 * time.Second}).Receive(identity)
+	c.Assert(err, Equals, ErrExpiredProfile)
+	c.Assert(toSend, IsNil)
+}
+
+func (s *OTR4Suite) Test_DAKEIgnoresUnexpectedAuthMessages(c *C) {
+	bob := &Conversation{}
+	alice := &Conversation{}
+
+	identity, _ := bob.startDAKE()
+	_, authR, _ := alice.receiveDecoded(identity)
+
+	_, reply, err := alice.receiveDecoded(authR)
+
+	c.Assert(err, IsNil)
+	c.Assert(reply, IsNil)
+	c.Assert(alice.IsEncrypted(), Equals, false)
+}
+
+func (s *OTR4Suite) Test_DAKEWithBothSidesStarting(c *C) {
+	bob := &Conversation{}
+	alice := &Conversation{}
+
+	bobIdentity, _ := bob.startDAKE()
+	aliceIdentity, _ := alice.startDAKE()
+
+	_, toAlice, err := bob.receiveDecoded(aliceIdentity)
+	c.Assert(err, IsNil)
+	_, toBob, err := alice.receiveDecoded(bobIdentity)
+	c.Assert(err, IsNil)
+
+	// only the side with the smaller ECDH value answers
+	c.Assert(toAlice == nil, Not(Equals), toBob == nil)
+
+	first, second := alice, bob
+	authR := toBob
+	if toAlice != nil {
+		first, second = bob, alice
+		authR = toAlice
+	}
+
+	_, authI, err := second.receiveDecoded(authR)
+	c.Assert(err, IsNil)
+	_, _, err = first.receiveDecoded(authI)
+	c.Assert(err, IsNil)
+
+	c.Assert(alice.IsEncrypted(), Equals, true)
+	c.Assert(bob.IsEncrypted(), Equals, true)
+	c.Assert(alice.ssid, DeepEquals, bob.ssid)
+}
+
+func establishSession(c *C) (alice, bob *Conversation) {
+	alice, bob = &Conversation{}, &Conversation{}
+	establishSessionBetween(c, alice, bob)
+
+	return alice, bob
+}
+
+func establishSessionBetween(c *C, alice, bob *Conversation) {
+	identity, err := bob.startDAKE()
+	c.Assert(err, IsNil)
+	_, authR, err := alice.receiveDecoded(identity)
+	c.Assert(err, IsNil)
+	_, authI, err := bob.receiveDecoded(authR)
+	c.Assert(err, IsNil)
+	_, _, err = alice.receiveDecoded(authI)
+	c.Assert(err, IsNil)
+}
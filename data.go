@@ -1,6 +1,7 @@
 package otr4
 
 import (
+	"bytes"
 	"math/big"
 	"strconv"
 
@@ -28,6 +29,19 @@ func hashToScalar(i byte, in ...ed448.Point) ed448.Scalar {
 	return c
 }
 
+func kdf(usageID byte, size int, values ...[]byte) []byte {
+	hash := sha3.NewShake256()
+	hash.Write(kdfDomain)
+	hash.Write([]byte{usageID})
+	for _, v := range values {
+		hash.Write(v)
+	}
+
+	out := make([]byte, size)
+	hash.Read(out)
+	return out
+}
+
 func appendBytes(bs ...interface{}) []byte {
 	var b []byte
 
@@ -54,6 +68,10 @@ func appendAndHash(bs ...interface{}) ed448.Scalar {
 	return shakeToScalar(appendBytes(bs...))
 }
 
+func appendWord16(b []byte, data uint16) []byte {
+	return append(b, byte(data>>8), byte(data))
+}
+
 func appendWord32(b []byte, data uint32) []byte {
 	return append(b, byte(data>>24), byte(data>>16), byte(data>>8), byte(data))
 }
@@ -70,22 +88,33 @@ func appendMPI(b []byte, data *big.Int) []byte {
 	return appendData(b, data.Bytes())
 }
 
+func appendScalar(b []byte, s ed448.Scalar) []byte {
+	return append(b, s.Encode()...)
+}
+
 func appendPoint(b []byte, p ed448.Point) []byte {
 	return append(b, p.DSAEncode()...)
 }
 
-//func appendSignature(bs []byte, data interface{}) []byte {
-//	switch d := data.(type) {
-//	case *signature:
-//		b := serializeSignature(d)
-//		return append(bs, b[:]...)
-//	case *dsaSignature:
-//		var b [dsaSigBytes]byte
-//		copy(b[:], d[:])
-//		return append(bs, b[:]...)
-//	}
-//	return nil
-//}
+func appendSignature(bs []byte, data interface{}) []byte {
+	switch d := data.(type) {
+	case *signature:
+		return append(bs, d[:]...)
+	case *dsaSignature:
+		return append(bs, d[:]...)
+	}
+
+	panic("programmer error: invalid signature")
+}
+
+func extractWord16(bs []byte) ([]byte, uint16, bool) {
+	if len(bs) < 2 {
+		return nil, 0, false
+	}
+
+	return bs[2:], uint16(bs[0])<<8 |
+		uint16(bs[1]), true
+}
 
 func extractWord32(bs []byte) ([]byte, uint32, bool) {
 	if len(bs) < 4 {
@@ -99,11 +128,11 @@ func extractWord32(bs []byte) ([]byte, uint32, bool) {
 }
 
 func extractWord64(bs []byte) ([]byte, uint64, bool) {
-	if len(bs) < 4 {
+	if len(bs) < 8 {
 		return nil, 0, false
 	}
 
-	return bs[4:], uint64(bs[0])<<56 |
+	return bs[8:], uint64(bs[0])<<56 |
 		uint64(bs[1])<<48 |
 		uint64(bs[2])<<40 |
 		uint64(bs[3])<<32 |
@@ -124,20 +153,42 @@ func extractData(bs []byte) ([]byte, []byte, bool) {
 	return cursor, data, ok
 }
 
+func extractMPI(bs []byte) ([]byte, *big.Int, bool) {
+	cursor, data, ok := extractData(bs)
+	if !ok {
+		return bs, nil, false
+	}
+
+	return cursor, new(big.Int).SetBytes(data), true
+}
+
+func extractScalar(bs []byte) ([]byte, ed448.Scalar, error) {
+	if len(bs) < fieldBytes {
+		return nil, nil, errInvalidLength
+	}
+
+	s := ed448.NewScalar(bs[:fieldBytes])
+	if !bytes.Equal(s.Encode(), bs[:fieldBytes]) {
+		return nil, nil, errNonCanonicalScalar
+	}
+
+	return bs[fieldBytes:], s, nil
+}
+
 func extractPoint(b []byte, cursor int) (ed448.Point, int, error) {
-	if len(b) < 56 {
+	if len(b)-cursor < fieldBytes {
 		return nil, 0, errInvalidLength
 	}
 
 	p := ed448.NewPointFromBytes()
 	valid, err := p.Decode(b[cursor:cursor+fieldBytes], false)
 	if !valid {
-		return nil, 0, err
+		return nil, 0, firstError(err, errInvalidPoint)
 	}
 
 	cursor += fieldBytes
 
-	return p, cursor, err
+	return p, cursor, nil
 }
 
 func fromHexChar(c byte) (byte, bool) {
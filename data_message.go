@@ -0,0 +1,196 @@
+package otr4
+
+import (
+	"crypto/subtle"
+	"io"
+	"math/big"
+
+	"golang.org/x/crypto/salsa20"
+)
+
+const dataMsgType byte = 0x03
+
+const (
+	nonceBytes         = 24
+	authenticatorBytes = 64
+	macKeyBytes        = 64
+)
+
+const (
+	flagIgnoreUnreadable byte = 0x01
+)
+
+type dataMessage struct {
+	flags byte
+	ratchetHeader
+	nonce         [nonceBytes]byte
+	ciphertext    []byte
+	authenticator [authenticatorBytes]byte
+	// revealedMACKeys are old MAC keys published for deniability. They
+	// are sent after the authenticator, so they are not authenticated.
+	revealedMACKeys []byte
+}
+
+// serializeBody returns everything but the authenticator, which is what the
+// authenticator is computed over
+func (m *dataMessage) serializeBody() []byte {
+	out := []byte{m.flags}
+	out = appendWord32(out, m.previousN)
+	out = appendWord32(out, m.ratchetID)
+	out = appendWord32(out, m.messageID)
+	out = append(out, m.ecdh.Encode()...)
+
+	if m.dh != nil {
+		out = appendMPI(out, m.dh)
+	} else {
+		out = appendWord32(out, 0)
+	}
+
+	out = append(out, m.nonce[:]...)
+	return appendData(out, m.ciphertext)
+}
+
+func (m *dataMessage) serialize() []byte {
+	out := append(m.serializeBody(), m.authenticator[:]...)
+	return appendData(out, m.revealedMACKeys)
+}
+
+func (m *dataMessage) deserialize(msg []byte) error {
+	if len(msg) < 1 {
+		return errInvalidLength
+	}
+
+	m.flags = msg[0]
+	cursor := msg[1:]
+
+	var ok bool
+	for _, w := range []*uint32{&m.previousN, &m.ratchetID, &m.messageID} {
+		cursor, *w, ok = extractWord32(cursor)
+		if !ok {
+			return errInvalidLength
+		}
+	}
+
+	var err error
+	m.ecdh, cursor, err = extractECDHPoint(cursor)
+	if err != nil {
+		return err
+	}
+
+	var dh []byte
+	cursor, dh, ok = extractData(cursor)
+	if !ok {
+		return errInvalidLength
+	}
+
+	m.dh = nil
+	if len(dh) > 0 {
+		m.dh = new(big.Int).SetBytes(dh)
+		if !isGroupElement(m.dh) {
+			return errInvalidDHValue
+		}
+	}
+
+	if len(cursor) < nonceBytes {
+		return errInvalidLength
+	}
+	copy(m.nonce[:], cursor)
+	cursor = cursor[nonceBytes:]
+
+	cursor, m.ciphertext, ok = extractData(cursor)
+	if !ok {
+		return errInvalidLength
+	}
+
+	if len(cursor) < authenticatorBytes {
+		return errInvalidLength
+	}
+	copy(m.authenticator[:], cursor)
+	cursor = cursor[authenticatorBytes:]
+
+	cursor, m.revealedMACKeys, ok = extractData(cursor)
+	if !ok || len(cursor) != 0 || len(m.revealedMACKeys)%macKeyBytes != 0 {
+		return errInvalidLength
+	}
+
+	return nil
+}
+
+func encryptionKey(mk []byte) *[32]byte {
+	var key [32]byte
+	copy(key[:], kdf(usageEncryptionKey, 32, mk))
+	return &key
+}
+
+func macKey(mk []byte) []byte {
+	return kdf(usageMACKey, macKeyBytes, mk)
+}
+
+// authenticate computes the authenticator over the message, including the
+// protocol header, as sent on the wire
+func authenticate(macKey, msg []byte) [authenticatorBytes]byte {
+	var out [authenticatorBytes]byte
+	copy(out[:], kdf(usageAuthenticator, authenticatorBytes, macKey, msg))
+	return out
+}
+
+func (c *Conversation) createDataMessage(plaintext []byte, flags byte) ([]byte, error) {
+	h, mk, err := c.ratchet.sendingMessageKey(c.rand())
+	if err != nil {
+		return nil, err
+	}
+
+	m := &dataMessage{flags: flags, ratchetHeader: h}
+
+	if _, err := io.ReadFull(c.rand(), m.nonce[:]); err != nil {
+		return nil, notEnoughEntropy
+	}
+
+	m.ciphertext = make([]byte, len(plaintext))
+	salsa20.XORKeyStream(m.ciphertext, plaintext, m.nonce[:], encryptionKey(mk))
+
+	msg, err := c.wrapMessage(dataMsgType, m.serializeBody())
+	if err != nil {
+		return nil, err
+	}
+
+	m.authenticator = authenticate(macKey(mk), msg)
+	m.revealedMACKeys = c.macKeys.reveal()
+
+	msg = append(msg, m.authenticator[:]...)
+	return appendData(msg, m.revealedMACKeys), nil
+}
+
+// receiveDataMessage authenticates and decrypts a data message. The ratchet
+// only moves forward once the message has been authenticated.
+func (c *Conversation) receiveDataMessage(msg, body []byte) ([]byte, error) {
+	if c.msgState != encrypted || c.ratchet == nil {
+		return nil, errImpossibleToDecrypt
+	}
+
+	m := &dataMessage{}
+	if err := m.deserialize(body); err != nil {
+		return nil, err
+	}
+
+	r := c.ratchet.clone()
+	mk, err := r.receivingMessageKey(m.ratchetHeader)
+	if err != nil {
+		return nil, err
+	}
+
+	authenticated := msg[:len(msg)-authenticatorBytes-4-len(m.revealedMACKeys)]
+	mac := macKey(mk)
+	expected := authenticate(mac, authenticated)
+	if subtle.ConstantTimeCompare(expected[:], m.authenticator[:]) != 1 {
+		return nil, errImpossibleToDecrypt
+	}
+
+	c.ratchet = r
+	c.macKeys.used(mac)
+
+	plaintext := make([]byte, len(m.ciphertext))
+	salsa20.XORKeyStream(plaintext, m.ciphertext, m.nonce[:], encryptionKey(mk))
+
+	return plaintext, nil
+}
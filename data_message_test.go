@@ -0,0 +1,126 @@
+package otr4
+
+import (
+	"crypto/rand"
+
+	. "gopkg.in/check.v1"
+)
+
+func (s *OTR4Suite) Test_DataMessageSerialization(c *C) {
+	ecdh, err := generateECDHKeyPair(rand.Reader)
+	c.Assert(err, IsNil)
+	dh, err := generateDHKeyPair(rand.Reader)
+	c.Assert(err, IsNil)
+
+	m := &dataMessage{
+		flags: flagIgnoreUnreadable,
+		ratchetHeader: ratchetHeader{
+			ratchetID: 3,
+			messageID: 2,
+			previousN: 1,
+			ecdh:      ecdh.pub,
+			dh:        dh.pub,
+		},
+		ciphertext:      []byte("ciphertext"),
+		revealedMACKeys: make([]byte, 2*macKeyBytes),
+	}
+	m.nonce[0] = 0x01
+	m.authenticator[0] = 0x02
+
+	d := &dataMessage{}
+	err = d.deserialize(m.serialize())
+
+	c.Assert(err, IsNil)
+	c.Assert(d.flags, Equals, flagIgnoreUnreadable)
+	c.Assert(d.ratchetID, Equals, uint32(3))
+	c.Assert(d.messageID, Equals, uint32(2))
+	c.Assert(d.previousN, Equals, uint32(1))
+	c.Assert(d.ecdh.Equals(ecdh.pub), Equals, true)
+	c.Assert(d.dh, DeepEquals, dh.pub)
+	c.Assert(d.nonce, DeepEquals, m.nonce)
+	c.Assert(d.ciphertext, DeepEquals, m.ciphertext)
+	c.Assert(d.authenticator, DeepEquals, m.authenticator)
+	c.Assert(d.revealedMACKeys, DeepEquals, m.revealedMACKeys)
+}
+
+func (s *OTR4Suite) Test_DataMessageWithoutDHValue(c *C) {
+	ecdh, err := generateECDHKeyPair(rand.Reader)
+	c.Assert(err, IsNil)
+
+	m := &dataMessage{ratchetHeader: ratchetHeader{ecdh: ecdh.pub}}
+
+	d := &dataMessage{}
+	err = d.deserialize(m.serialize())
+
+	c.Assert(err, IsNil)
+	c.Assert(d.dh, IsNil)
+}
+
+func (s *OTR4Suite) Test_DataMessageDeserializeRejectsInvalidLengths(c *C) {
+	ecdh, err := generateECDHKeyPair(rand.Reader)
+	c.Assert(err, IsNil)
+
+	m := &dataMessage{
+		ratchetHeader: ratchetHeader{ecdh: ecdh.pub},
+		ciphertext:    []byte("ciphertext"),
+	}
+	ser := m.serialize()
+
+	c.Assert((&dataMessage{}).deserialize(nil), Equals, errInvalidLength)
+	c.Assert((&dataMessage{}).deserialize(ser[:len(ser)-1]), Equals, errInvalidLength)
+	c.Assert((&dataMessage{}).deserialize(append(ser, 0x00)), Equals, errInvalidLength)
+
+	m.revealedMACKeys = make([]byte, macKeyBytes-1)
+	c.Assert((&dataMessage{}).deserialize(m.serialize()), Equals, errInvalidLength)
+}
+
+func (s *OTR4Suite) Test_SendAndReceiveDataMessages(c *C) {
+	alice, bob := establishSession(c)
+
+	toSend, err := bob.Send([]byte("hi alice"))
+	c.Assert(err, IsNil)
+	c.Assert(toSend, HasLen, 1)
+
+	plain, reply, err := alice.Receive(toSend[0])
+	c.Assert(err, IsNil)
+	c.Assert(reply, IsNil)
+	c.Assert(plain, DeepEquals, []byte("hi alice"))
+
+	toSend, err = alice.Send([]byte("hi bob"))
+	c.Assert(err, IsNil)
+
+	plain, _, err = bob.Receive(toSend[0])
+	c.Assert(err, IsNil)
+	c.Assert(plain, DeepEquals, []byte("hi bob"))
+}
+
+func (s *OTR4Suite) Test_ReceiveRejectsATamperedDataMessage(c *C) {
+	alice, bob := establishSession(c)
+
+	msg, err := bob.createDataMessage([]byte("hi alice"), 0)
+	c.Assert(err, IsNil)
+
+	tampered := append([]byte{}, msg...)
+	// the authenticator is followed by the empty list of revealed MAC keys
+	tampered[len(tampered)-5] ^= 0x01
+
+	before := alice.ratchet
+	_, _, err = alice.receiveDecoded(tampered)
+
+	c.Assert(err, Equals, errImpossibleToDecrypt)
+	c.Assert(alice.ratchet, Equals, before)
+
+	plain, _, err := alice.receiveDecoded(msg)
+	c.Assert(err, IsNil)
+	c.Assert(plain, DeepEquals, []byte("hi alice"))
+}
+
+func (s *OTR4Suite) Test_ReceiveRejectsADataMessageBeforeTheDAKE(c *C) {
+	_, bob := establishSession(c)
+
+	msg, err := bob.createDataMessage([]byte("hi"), 0)
+	c.Assert(err, IsNil)
+
+	_, _, err = (&Conversation{}).receiveDecoded(msg)
+	c.Assert(err, Equals, errImpossibleToDecrypt)
+}
@@ -88,6 +88,13 @@ func (s *OTR4Suite) Test_AppendAndHash(c *C) {
 	c.Assert(hash, DeepEquals, exp)
 }
 
+func (s *OTR4Suite) Test_AppendWord16(c *C) {
+	bs := []byte{0xcc, 0x12}
+	rslt := appendWord16(bs, 0xFF38)
+
+	c.Assert(rslt, DeepEquals, []byte{0xcc, 0x12, 0xFF, 0x38})
+}
+
 func (s *OTR4Suite) Test_AppendWord32(c *C) {
 	bs := []byte{0xcc, 0x12}
 	rslt := appendWord32(bs, 0xFF3824F7)
@@ -247,6 +254,22 @@ func (s *OTR4Suite) Test_AppendPoint(c *C) {
 //	c.Assert(ser, IsNil)
 //}
 //
+func (s *OTR4Suite) Test_ExtractWord16(c *C) {
+	bs := []byte{0x12}
+	i, rslt, ok := extractWord16(bs)
+
+	c.Assert(i, IsNil)
+	c.Assert(rslt, Equals, uint16(0x0))
+	c.Assert(ok, Equals, false)
+
+	bs = []byte{0x12, 0x14, 0x03}
+	i, rslt, ok = extractWord16(bs)
+
+	c.Assert(i, DeepEquals, []byte{0x03})
+	c.Assert(rslt, Equals, uint16(0x1214))
+	c.Assert(ok, Equals, true)
+}
+
 func (s *OTR4Suite) Test_ExtractWord32(c *C) {
 	bs := []byte{0x12, 0x14, 0x15}
 	i, rslt, ok := extractWord32(bs)
@@ -273,9 +296,10 @@ func (s *OTR4Suite) Test_ExtractWord64(c *C) {
 	bs = []byte{0x12, 0x14, 0x15, 0xff, 0x03,
 		0x12, 0x14, 0x15, 0xff, 0x03,
 	}
-	_, rslt, ok = extractWord64(bs)
+	cursor, rslt, ok := extractWord64(bs)
 
 	c.Assert(rslt, DeepEquals, uint64(0x121415ff03121415))
+	c.Assert(cursor, DeepEquals, []byte{0xff, 0x03})
 	c.Assert(ok, Equals, true)
 }
 
@@ -288,6 +312,27 @@ func (s *OTR4Suite) Test_ExtractData(c *C) {
 	c.Assert(ok, Equals, true)
 }
 
+func (s *OTR4Suite) Test_ExtractScalar(c *C) {
+	bs := append(testPrivA.r.Encode(), 0x01)
+	rest, sc, err := extractScalar(bs)
+
+	c.Assert(err, IsNil)
+	c.Assert(sc, DeepEquals, testPrivA.r)
+	c.Assert(rest, DeepEquals, []byte{0x01})
+
+	_, _, err = extractScalar(bs[:55])
+
+	c.Assert(err, ErrorMatches, ".* invalid length")
+
+	bs = make([]byte, 56)
+	for i := range bs {
+		bs[i] = 0xff
+	}
+	_, _, err = extractScalar(bs)
+
+	c.Assert(err, ErrorMatches, ".* non-canonical scalar")
+}
+
 func (s *OTR4Suite) Test_ExtractPoint(c *C) {
 	bs, _ := hex.DecodeString("e4b2a1a14395b5eb3a5c3f3d265782efc28b9a94c" +
 		"c1d46fff8725079cee988d0955a3da9a2ef30abc30ef1bd947f48e093aa" +
@@ -337,6 +382,15 @@ func (s *OTR4Suite) Test_ExtractPoint(c *C) {
 	c.Assert(p, DeepEquals, nil)
 	c.Assert(cursor, Equals, 0)
 	c.Assert(err, ErrorMatches, "*. invalid length")
+
+	bs = make([]byte, fieldBytes+1)
+	cursor = 2
+
+	p, cursor, err = extractPoint(bs, cursor)
+
+	c.Assert(p, DeepEquals, nil)
+	c.Assert(cursor, Equals, 0)
+	c.Assert(err, ErrorMatches, "*. invalid length")
 }
 
 func (s *OTR4Suite) Test_FromHexChar(c *C) {
@@ -1,6 +1,9 @@
 package otr4
 
-import "math/big"
+import (
+	"io"
+	"math/big"
+)
 
 var (
 	p         *big.Int // prime field, assigned in RFC3526 with id 15
@@ -54,3 +57,29 @@ func init() {
 func isGroupElement(n *big.Int) bool {
 	return greatOrEqual(n, g3) && lessOrEqual(n, pMinusTwo)
 }
+
+// dhPrivBytes is the size of a Diffie-Hellman private exponent, 640 bits
+const dhPrivBytes = 80
+
+type dhKeyPair struct {
+	pub  *big.Int
+	priv *big.Int
+}
+
+func generateDHKeyPair(rand io.Reader) (*dhKeyPair, error) {
+	var b [dhPrivBytes]byte
+
+	_, err := io.ReadFull(rand, b[:])
+	if err != nil {
+		return nil, notEnoughEntropy
+	}
+
+	priv := new(big.Int).SetBytes(b[:])
+	pub := new(big.Int).Exp(g3, priv, p)
+
+	return &dhKeyPair{pub: pub, priv: priv}, nil
+}
+
+func dhSecret(priv, pub *big.Int) []byte {
+	return new(big.Int).Exp(pub, priv, p).Bytes()
+}
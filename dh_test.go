@@ -1,6 +1,7 @@
 package otr4
 
 import (
+	"crypto/rand"
 	"math/big"
 
 	. "gopkg.in/check.v1"
@@ -31,3 +32,16 @@ func (s *OTR4Suite) Test_ValidationOfDHGroupElement(c *C) {
 	valid = isGroupElement(big.NewInt(2))
 	c.Assert(valid, Equals, true)
 }
+
+func (s *OTR4Suite) Test_DHKeyPairAgreement(c *C) {
+	a, err := generateDHKeyPair(rand.Reader)
+	c.Assert(err, IsNil)
+	b, err := generateDHKeyPair(rand.Reader)
+	c.Assert(err, IsNil)
+
+	c.Assert(isGroupElement(a.pub), Equals, true)
+	c.Assert(dhSecret(a.priv, b.pub), DeepEquals, dhSecret(b.priv, a.pub))
+
+	_, err = generateDHKeyPair(fixedRand([]byte{0x01}))
+	c.Assert(err, ErrorMatches, ".*cannot source enough entropy")
+}
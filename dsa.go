@@ -0,0 +1,83 @@
+package otr4
+
+import (
+	"crypto/dsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"io"
+	"math/big"
+)
+
+// dsaKeyType is the type of OTRv3 DSA public keys
+const dsaKeyType = 0x0000
+
+const dsaSubgroupBits = 160
+
+// serializeDSAPublicKey encodes an OTRv3 public key: its type, followed by
+// p, q, g and y
+func serializeDSAPublicKey(pub *dsa.PublicKey) []byte {
+	out := appendWord16(nil, dsaKeyType)
+	out = appendMPI(out, pub.P)
+	out = appendMPI(out, pub.Q)
+	out = appendMPI(out, pub.G)
+	return appendMPI(out, pub.Y)
+}
+
+func deserializeDSAPublicKey(ser []byte) (*dsa.PublicKey, []byte, error) {
+	cursor, typ, ok := extractWord16(ser)
+	if !ok {
+		return nil, nil, errInvalidLength
+	}
+
+	if typ != dsaKeyType {
+		return nil, nil, errInvalidDSAKey
+	}
+
+	pub := &dsa.PublicKey{}
+	for _, v := range []**big.Int{&pub.P, &pub.Q, &pub.G, &pub.Y} {
+		cursor, *v, ok = extractMPI(cursor)
+		if !ok {
+			return nil, nil, errInvalidLength
+		}
+	}
+
+	if pub.Q.BitLen() != dsaSubgroupBits {
+		return nil, nil, errInvalidDSAKey
+	}
+
+	return pub, cursor, nil
+}
+
+// otrV3Fingerprint returns the fingerprint OTRv3 clients show for a DSA
+// public key: the SHA-1 of the key without its type
+func otrV3Fingerprint(pub *dsa.PublicKey) []byte {
+	h := sha1.Sum(serializeDSAPublicKey(pub)[2:])
+	return h[:]
+}
+
+func dsaSign(rand io.Reader, priv *dsa.PrivateKey, msg []byte) (*dsaSignature, error) {
+	if priv.Q.BitLen() != dsaSubgroupBits {
+		return nil, errInvalidDSAKey
+	}
+
+	h := sha256.Sum256(msg)
+	r, s, err := dsa.Sign(rand, priv, h[:])
+	if err != nil {
+		return nil, notEnoughEntropy
+	}
+
+	// r and s are each smaller than q, which is 20 bytes long
+	sig := &dsaSignature{}
+	r.FillBytes(sig[:dsaSigBytes/2])
+	s.FillBytes(sig[dsaSigBytes/2:])
+
+	return sig, nil
+}
+
+func dsaVerify(pub *dsa.PublicKey, msg []byte, sig *dsaSignature) bool {
+	h := sha256.Sum256(msg)
+	r := new(big.Int).SetBytes(sig[:dsaSigBytes/2])
+	s := new(big.Int).SetBytes(sig[dsaSigBytes/2:])
+
+	return dsa.Verify(pub, h[:], r, s)
+}
@@ -0,0 +1,71 @@
+package otr4
+
+import (
+	"crypto/dsa"
+	"crypto/rand"
+
+	. "gopkg.in/check.v1"
+)
+
+var testDSAKey *dsa.PrivateKey
+
+// generateTestDSAKey returns an OTRv3 DSA key shared by the tests, since
+// generating the parameters is slow
+func generateTestDSAKey(c *C) *dsa.PrivateKey {
+	if testDSAKey != nil {
+		return testDSAKey
+	}
+
+	key := &dsa.PrivateKey{}
+	c.Assert(dsa.GenerateParameters(&key.Parameters, rand.Reader, dsa.L1024N160), IsNil)
+	c.Assert(dsa.GenerateKey(key, rand.Reader), IsNil)
+
+	testDSAKey = key
+	return key
+}
+
+func (s *OTR4Suite) Test_DSAPublicKeySerialization(c *C) {
+	key := generateTestDSAKey(c)
+
+	ser := serializeDSAPublicKey(&key.PublicKey)
+	c.Assert(ser[:2], DeepEquals, []byte{0x00, 0x00})
+
+	pub, rest, err := deserializeDSAPublicKey(append(ser, 0x01))
+
+	c.Assert(err, IsNil)
+	c.Assert(rest, DeepEquals, []byte{0x01})
+	c.Assert(pub, DeepEquals, &key.PublicKey)
+}
+
+func (s *OTR4Suite) Test_DeserializeDSAPublicKeyRejectsInvalidKeys(c *C) {
+	ser := serializeDSAPublicKey(&generateTestDSAKey(c).PublicKey)
+
+	_, _, err := deserializeDSAPublicKey(ser[:1])
+	c.Assert(err, Equals, errInvalidLength)
+
+	_, _, err = deserializeDSAPublicKey(ser[:len(ser)-1])
+	c.Assert(err, Equals, errInvalidLength)
+
+	wrongType := append([]byte{0x00, 0x10}, ser[2:]...)
+	_, _, err = deserializeDSAPublicKey(wrongType)
+	c.Assert(err, Equals, errInvalidDSAKey)
+}
+
+func (s *OTR4Suite) Test_OTRv3Fingerprint(c *C) {
+	key := generateTestDSAKey(c)
+
+	fp := otrV3Fingerprint(&key.PublicKey)
+
+	c.Assert(fp, HasLen, 20)
+	c.Assert(fp, DeepEquals, otrV3Fingerprint(&key.PublicKey))
+}
+
+func (s *OTR4Suite) Test_DSASignAndVerify(c *C) {
+	key := generateTestDSAKey(c)
+
+	sig, err := dsaSign(rand.Reader, key, []byte("hello"))
+
+	c.Assert(err, IsNil)
+	c.Assert(dsaVerify(&key.PublicKey, []byte("hello"), sig), Equals, true)
+	c.Assert(dsaVerify(&key.PublicKey, []byte("hellO"), sig), Equals, false)
+}
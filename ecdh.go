@@ -0,0 +1,28 @@
+package otr4
+
+import (
+	"io"
+
+	"github.com/twstrike/ed448"
+)
+
+type ecdhKeyPair struct {
+	pub  ed448.Point
+	priv ed448.Scalar
+}
+
+func generateECDHKeyPair(rand io.Reader) (*ecdhKeyPair, error) {
+	priv, err := randScalar(rand)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ecdhKeyPair{
+		pub:  ed448.PrecomputedScalarMul(priv),
+		priv: priv,
+	}, nil
+}
+
+func ecdhSecret(priv ed448.Scalar, pub ed448.Point) []byte {
+	return ed448.PointScalarMul(pub, priv).Encode()
+}
@@ -0,0 +1,20 @@
+package otr4
+
+import (
+	"crypto/rand"
+
+	. "gopkg.in/check.v1"
+)
+
+func (s *OTR4Suite) Test_ECDHKeyPairAgreement(c *C) {
+	a, err := generateECDHKeyPair(rand.Reader)
+	c.Assert(err, IsNil)
+	b, err := generateECDHKeyPair(rand.Reader)
+	c.Assert(err, IsNil)
+
+	c.Assert(a.pub.IsOnCurve(), Equals, true)
+	c.Assert(ecdhSecret(a.priv, b.pub), DeepEquals, ecdhSecret(b.priv, a.pub))
+
+	_, err = generateECDHKeyPair(fixedRand([]byte{0x01}))
+	c.Assert(err, ErrorMatches, ".*cannot source enough entropy")
+}
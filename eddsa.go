@@ -0,0 +1,126 @@
+package otr4
+
+import (
+	"bytes"
+	"math/big"
+
+	"github.com/twstrike/ed448"
+	"golang.org/x/crypto/sha3"
+)
+
+// ed448Order is the order of the Ed448 base point:
+// 2^446 - 13818066809895115352007386748515426880336692474882178609894547503885
+var ed448Order *big.Int
+
+func init() {
+	c, _ := new(big.Int).SetString("13818066809895115352007386748515426880336692474882178609894547503885", 10)
+	ed448Order = new(big.Int).Sub(new(big.Int).Lsh(big.NewInt(1), 446), c)
+}
+
+const maxContextBytes = 255
+
+// dom4 is the prefix RFC 8032 uses to separate Ed448 signatures made with
+// different contexts
+func dom4(context []byte) []byte {
+	out := []byte("SigEd448")
+	out = append(out, 0x00, byte(len(context)))
+	return append(out, context...)
+}
+
+func reverse(bs []byte) []byte {
+	out := make([]byte, len(bs))
+	for i, b := range bs {
+		out[len(bs)-1-i] = b
+	}
+	return out
+}
+
+// littleEndianScalar reduces a little-endian integer of any length modulo
+// the group order
+func littleEndianScalar(bs []byte) ed448.Scalar {
+	n := new(big.Int).SetBytes(reverse(bs))
+	n.Mod(n, ed448Order)
+
+	var le [fieldBytes]byte
+	n.FillBytes(le[:])
+	return ed448.NewScalar(reverse(le[:]))
+}
+
+func hashToEdScalar(values ...[]byte) ed448.Scalar {
+	hash := sha3.NewShake256()
+	for _, v := range values {
+		hash.Write(v)
+	}
+
+	out := make([]byte, 2*privateKeySize)
+	hash.Read(out)
+	return littleEndianScalar(out)
+}
+
+// quarter returns s/4. Points are multiplied by the cofactor when they are
+// encoded, so scalars are divided by it before multiplying the base point.
+func quarter(s ed448.Scalar) ed448.Scalar {
+	q := s.Copy()
+	q.Halve(q)
+	q.Halve(q)
+	return q
+}
+
+func times4(s ed448.Scalar) ed448.Scalar {
+	t := ed448.NewScalar()
+	t.Add(s, s)
+	t.Add(t, t)
+	return t
+}
+
+// Sign signs the message following RFC 8032 Ed448, with the given context,
+// which can be empty. It returns a signature of signatureSize bytes.
+func (priv *privateKey) Sign(message, context []byte) ([]byte, error) {
+	if len(context) > maxContextBytes {
+		return nil, errInvalidContext
+	}
+
+	dom := dom4(context)
+	a := ed448.PrecomputedScalarMul(priv.r).DSAEncode()
+
+	r := hashToEdScalar(dom, priv.prefix, message)
+	rEnc := ed448.PrecomputedScalarMul(quarter(r)).DSAEncode()
+
+	k := hashToEdScalar(dom, rEnc, a, message)
+
+	s := ed448.NewScalar()
+	s.Mul(k, times4(priv.r))
+	s.Add(s, r)
+
+	sig := append(rEnc, s.Encode()...)
+	return append(sig, 0x00), nil
+}
+
+// Verify checks an RFC 8032 Ed448 signature of the message, made with the
+// given context.
+func (pub *publicKey) Verify(message, context, sig []byte) bool {
+	if len(sig) != signatureSize || len(context) > maxContextBytes {
+		return false
+	}
+
+	rEnc, sEnc := sig[:publicKeySize], sig[publicKeySize:]
+	if new(big.Int).SetBytes(reverse(sEnc)).Cmp(ed448Order) >= 0 {
+		return false
+	}
+
+	r := ed448.NewPointFromBytes()
+	if valid, _ := r.DSADecode(rEnc); !valid {
+		return false
+	}
+
+	s := ed448.NewScalar(sEnc[:fieldBytes])
+	k := hashToEdScalar(dom4(context), rEnc, pub.h.DSAEncode(), message)
+
+	// decoded points have been divided by the cofactor, so this checks
+	// [S]B = R + [k]A. R must also be canonically encoded.
+	lhs := ed448.PrecomputedScalarMul(quarter(s))
+	rhs := ed448.PointScalarMul(pub.h, k)
+	rhs.Add(rhs, r)
+
+	return lhs.Equals(rhs) && bytes.Equal(rEnc, r.DSAEncode())
+}
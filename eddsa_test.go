@@ -0,0 +1,105 @@
+package otr4
+
+import (
+	"encoding/hex"
+	"strings"
+
+	. "gopkg.in/check.v1"
+)
+
+// Ed448 test vectors from RFC 8032, section 7.4
+var rfc8032Vectors = []struct {
+	secret, public, message, context, signature string
+}{
+	{
+		secret: "6c82a562cb808d10d632be89c8513ebf6c929f34ddfa8c9f63c9960ef6e348a3" +
+			"528c8a3fcc2f044e39a3fc5b94492f8f032e7549a20098f95b",
+		public: "5fd7449b59b461fd2ce787ec616ad46a1da1342485a70e1f8a0ea75d80e96778" +
+			"edf124769b46c7061bd6783df1e50f6cd1fa1abeafe8256180",
+		message: "",
+		signature: "533a37f6bbe457251f023c0d88f976ae2dfb504a843e34d2074fd823d41a591f" +
+			"2b233f034f628281f2fd7a22ddd47d7828c59bd0a21bfd3980ff0d2028d4b18a" +
+			"9df63e006c5d1c2d345b925d8dc00b4104852db99ac5c7cdda8530a113a0f4db" +
+			"b61149f05a7363268c71d95808ff2e652600",
+	},
+	{
+		secret: "c4eab05d357007c632f3dbb48489924d552b08fe0c353a0d4a1f00acda2c463a" +
+			"fbea67c5e8d2877c5e3bc397a659949ef8021e954e0a12274e",
+		public: "43ba28f430cdff456ae531545f7ecd0ac834a55d9358c0372bfa0c6c6798c086" +
+			"6aea01eb00742802b8438ea4cb82169c235160627b4c3a9480",
+		message: "03",
+		signature: "26b8f91727bd62897af15e41eb43c377efb9c610d48f2335cb0bd0087810f435" +
+			"2541b143c4b981b7e18f62de8ccdf633fc1bf037ab7cd779805e0dbcc0aae1cb" +
+			"cee1afb2e027df36bc04dcecbf154336c19f0af7e0a6472905e799f1953d2a0f" +
+			"f3348ab21aa4adafd1d234441cf807c03a00",
+	},
+	{
+		secret: "c4eab05d357007c632f3dbb48489924d552b08fe0c353a0d4a1f00acda2c463a" +
+			"fbea67c5e8d2877c5e3bc397a659949ef8021e954e0a12274e",
+		public: "43ba28f430cdff456ae531545f7ecd0ac834a55d9358c0372bfa0c6c6798c086" +
+			"6aea01eb00742802b8438ea4cb82169c235160627b4c3a9480",
+		message: "03",
+		context: "666f6f",
+		signature: "d4f8f6131770dd46f40867d6fd5d5055de43541f8c5e35abbcd001b32a89f7d2" +
+			"151f7647f11d8ca2ae279fb842d607217fce6e042f6815ea000c85741de5c8da" +
+			"1144a6a1aba7f96de42505d7a7298524fda538fccbbb754f578c1cad10d54d0d" +
+			"5428407e85dcbc98a49155c13764e66c3c00",
+	},
+}
+
+func decodeHex(c *C, s string) []byte {
+	b, err := hex.DecodeString(s)
+	c.Assert(err, IsNil)
+	return b
+}
+
+func (s *OTR4Suite) Test_SignMatchesRFC8032Vectors(c *C) {
+	for i, v := range rfc8032Vectors {
+		pub, priv, err := generateKeys(fixedRand(decodeHex(c, v.secret)))
+		c.Assert(err, IsNil)
+		c.Assert(pub.h.DSAEncode(), DeepEquals, decodeHex(c, v.public), Commentf("vector %d", i))
+
+		sig, err := priv.Sign(decodeHex(c, v.message), decodeHex(c, v.context))
+		c.Assert(err, IsNil)
+		c.Assert(sig, DeepEquals, decodeHex(c, v.signature), Commentf("vector %d", i))
+	}
+}
+
+func (s *OTR4Suite) Test_VerifyAcceptsRFC8032Vectors(c *C) {
+	for i, v := range rfc8032Vectors {
+		pub, _, err := generateKeys(fixedRand(decodeHex(c, v.secret)))
+		c.Assert(err, IsNil)
+
+		valid := pub.Verify(decodeHex(c, v.message), decodeHex(c, v.context), decodeHex(c, v.signature))
+		c.Assert(valid, Equals, true, Commentf("vector %d", i))
+	}
+}
+
+func (s *OTR4Suite) Test_VerifyRejectsInvalidSignatures(c *C) {
+	v := rfc8032Vectors[2]
+	pub, _, err := generateKeys(fixedRand(decodeHex(c, v.secret)))
+	c.Assert(err, IsNil)
+
+	msg, ctx, sig := decodeHex(c, v.message), decodeHex(c, v.context), decodeHex(c, v.signature)
+
+	c.Assert(pub.Verify(msg, nil, sig), Equals, false)
+	c.Assert(pub.Verify([]byte{0x04}, ctx, sig), Equals, false)
+	c.Assert(pub.Verify(msg, ctx, sig[:signatureSize-1]), Equals, false)
+
+	tampered := append([]byte{}, sig...)
+	tampered[60] ^= 0x01
+	c.Assert(pub.Verify(msg, ctx, tampered), Equals, false)
+
+	// S must be smaller than the group order
+	tampered = append([]byte{}, sig...)
+	copy(tampered[publicKeySize:], decodeHex(c, strings.Repeat("ff", fieldBytes)+"00"))
+	c.Assert(pub.Verify(msg, ctx, tampered), Equals, false)
+}
+
+func (s *OTR4Suite) Test_SignRejectsTooLongContexts(c *C) {
+	_, priv, err := generateKeys(fixedRand(decodeHex(c, rfc8032Vectors[0].secret)))
+	c.Assert(err, IsNil)
+
+	_, err = priv.Sign([]byte("hello"), make([]byte, maxContextBytes+1))
+	c.Assert(err, Equals, errInvalidContext)
+}
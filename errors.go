@@ -5,6 +5,46 @@ var errImpossibleToDecrypt = newOtrError("cannot decrypt the message")
 var errInvalidVersion = newOtrError("no valid version agreement could be found")
 var errInvalidLength = newOtrError("invalid length")
 var errCorruptEncryptedSignature = newOtrError("corrupted signature")
+var errInvalidOTRMessage = newOtrError("invalid OTR message")
+var errUnsupportedMessage = newOtrError("unsupported message type")
+var errUnexpectedState = newOtrError("message cannot be handled in the current state")
+var errInvalidPoint = newOtrError("invalid point")
+var errInvalidKeyType = newOtrError("invalid public key type")
+var errNonCanonicalScalar = newOtrError("non-canonical scalar")
+var errInvalidDHValue = newOtrError("invalid Diffie-Hellman value")
+var errInvalidAuth = newOtrError("DAKE authentication failed")
+var errReplayedMessage = newOtrError("message has already been received")
+var errTooManySkippedMessages = newOtrError("too many skipped messages")
+var errConversationFinished = newOtrError("conversation has been finished by the peer")
+var errInvalidTLV = newOtrError("malformed TLV")
+var errReservedTLVType = newOtrError("TLV type is reserved by the protocol")
+var errInvalidFragment = newOtrError("invalid fragment")
+var errFragmentSizeTooSmall = newOtrError("fragment size is too small")
+var errInvalidDSAKey = newOtrError("invalid OTRv3 DSA key")
+var errInvalidContext = newOtrError("signature context is too long")
+var errInvalidPEM = newOtrError("invalid PEM block")
+var errInvalidKeyEncoding = newOtrError("invalid key encoding")
+var errUnsupportedKeyAlgorithm = newOtrError("key is not an Ed448 key")
+var errInvalidKeyFile = newOtrError("invalid key file")
+var errCorruptKeyFile = newOtrError("key file is corrupted or the passphrase is wrong")
+var errPassphraseRequired = newOtrError("key file is encrypted and needs a passphrase")
+var errInvalidFingerprint = newOtrError("invalid fingerprint")
+var errInvalidInstanceTag = newOtrError("invalid instance tag")
+var errInvalidInstanceTagFile = newOtrError("invalid instance tag file")
+var errUnexpectedSMPMessage = newOtrError("unexpected SMP message")
+var errSMPCheated = newOtrError("SMP proof verification failed")
+var errInvalidSMPQuestion = newOtrError("SMP question is not valid UTF-8")
+
+// Errors returned when a client profile is rejected
+var (
+	ErrInvalidProfileSignature       = newProfileError("invalid signature")
+	ErrExpiredProfile                = newProfileError("expired")
+	ErrInvalidProfileVersions        = newProfileError("versions must include 4 and exclude 1 and 2")
+	ErrInvalidProfileKey             = newProfileError("public key is not a valid point")
+	ErrProfileInstanceTagMismatch    = newProfileError("instance tag does not match the sender")
+	ErrInvalidTransitionSignature    = newProfileError("invalid transitional signature")
+	ErrTransitionFingerprintMismatch = newProfileError("transitional signature is not from the expected OTRv3 key")
+)
 
 type otrError struct {
 	msg string
@@ -18,6 +58,20 @@ func (oe otrError) Error() string {
 	return "otr: " + oe.msg
 }
 
+// ProfileError is the type of the errors returned when a client profile is
+// rejected
+type ProfileError struct {
+	reason string
+}
+
+func newProfileError(s string) error {
+	return ProfileError{reason: s}
+}
+
+func (pe ProfileError) Error() string {
+	return "otr: invalid profile: " + pe.reason
+}
+
 func firstError(errs ...error) error {
 	for _, err := range errs {
 		if err != nil {
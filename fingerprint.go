@@ -0,0 +1,177 @@
+package otr4
+
+import (
+	"encoding/base32"
+	"encoding/hex"
+	"strings"
+	"unicode"
+)
+
+const fingerprintBytes = 56
+
+// Fingerprint identifies a long-term public key. Users compare them to
+// verify they are talking to who they think they are.
+type Fingerprint [fingerprintBytes]byte
+
+// fingerprintQRPrefix starts the QR code payload. Together with base32,
+// it only uses characters that fit the QR alphanumeric mode.
+const fingerprintQRPrefix = "OTR4:"
+
+var fingerprintEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+func (pub *publicKey) fingerprint() Fingerprint {
+	var fp Fingerprint
+	copy(fp[:], kdf(usageFingerprint, fingerprintBytes, pub.serialize()))
+	return fp
+}
+
+// String returns the fingerprint in hex, in groups of 8 characters.
+func (fp Fingerprint) String() string {
+	h := strings.ToUpper(hex.EncodeToString(fp[:]))
+
+	groups := make([]string, 0, len(h)/8)
+	for i := 0; i < len(h); i += 8 {
+		groups = append(groups, h[i:i+8])
+	}
+
+	return strings.Join(groups, " ")
+}
+
+// Words returns the fingerprint as a list of words, one per byte, which
+// is easier to read out over the phone.
+func (fp Fingerprint) Words() string {
+	words := make([]string, len(fp))
+	for i, b := range fp {
+		words[i] = fingerprintWords[b]
+	}
+
+	return strings.Join(words, " ")
+}
+
+// QRPayload returns a compact form of the fingerprint to put in a QR code.
+func (fp Fingerprint) QRPayload() string {
+	return fingerprintQRPrefix + fingerprintEncoding.EncodeToString(fp[:])
+}
+
+func fingerprintFromBytes(bs []byte) (Fingerprint, error) {
+	var fp Fingerprint
+	if len(bs) != fingerprintBytes {
+		return fp, errInvalidFingerprint
+	}
+
+	copy(fp[:], bs)
+	return fp, nil
+}
+
+// ParseFingerprint parses a fingerprint in hex. Case and whitespace are
+// ignored.
+func ParseFingerprint(s string) (Fingerprint, error) {
+	bs, err := hex.DecodeString(strings.Join(strings.Fields(s), ""))
+	if err != nil {
+		return Fingerprint{}, errInvalidFingerprint
+	}
+
+	return fingerprintFromBytes(bs)
+}
+
+// ParseFingerprintWords parses a fingerprint returned by Words. Case is
+// ignored, and words can be separated by whitespace or hyphens.
+func ParseFingerprintWords(s string) (Fingerprint, error) {
+	words := strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return r == '-' || unicode.IsSpace(r)
+	})
+
+	bs := make([]byte, 0, len(words))
+	for _, w := range words {
+		b, ok := fingerprintWordIndex[w]
+		if !ok {
+			return Fingerprint{}, errInvalidFingerprint
+		}
+		bs = append(bs, b)
+	}
+
+	return fingerprintFromBytes(bs)
+}
+
+// ParseFingerprintQR parses a payload returned by QRPayload.
+func ParseFingerprintQR(s string) (Fingerprint, error) {
+	if !strings.HasPrefix(s, fingerprintQRPrefix) {
+		return Fingerprint{}, errInvalidFingerprint
+	}
+
+	bs, err := fingerprintEncoding.DecodeString(s[len(fingerprintQRPrefix):])
+	if err != nil {
+		return Fingerprint{}, errInvalidFingerprint
+	}
+
+	return fingerprintFromBytes(bs)
+}
+
+// OurFingerprint returns the fingerprint of our long-term public key.
+func (c *Conversation) OurFingerprint() (Fingerprint, error) {
+	keys, err := c.ourKeys()
+	if err != nil {
+		return Fingerprint{}, err
+	}
+
+	return keys.pub.fingerprint(), nil
+}
+
+// TheirFingerprint returns the fingerprint of the peer's long-term public
+// key. It is only known once the DAKE has completed.
+func (c *Conversation) TheirFingerprint() (Fingerprint, bool) {
+	if c.theirPub == nil {
+		return Fingerprint{}, false
+	}
+
+	return c.theirPub.fingerprint(), true
+}
+
+// fingerprintWords has one word for each byte value
+var fingerprintWords = [256]string{
+	"acid", "acorn", "actor", "adobe", "agent", "alarm", "album", "alley",
+	"amber", "angle", "ankle", "apple", "apron", "arena", "armor",
+	"arrow", "atlas", "attic", "autumn", "award", "bacon", "badge",
+	"bagel", "baker", "bamboo", "banjo", "barn", "basil", "basket",
+	"beach", "beard", "beetle", "bell", "bench", "berry", "bison",
+	"blade", "blanket", "bloom", "board", "bonus", "boots", "bottle",
+	"bread", "brick", "bridge", "broom", "bucket", "bugle", "butter",
+	"cabin", "cactus", "camel", "candle", "canoe", "canvas", "carpet",
+	"carrot", "castle", "cattle", "cedar", "cello", "chain", "chalk",
+	"cherry", "chess", "circus", "clock", "cloud", "clover", "coast",
+	"cobra", "cocoa", "comet", "copper", "coral", "cotton", "crane",
+	"crater", "crayon", "crown", "cuckoo", "daisy", "dancer", "delta",
+	"desert", "diamond", "dinner", "dolphin", "donkey", "dragon", "drum",
+	"eagle", "easel", "echo", "elbow", "ember", "engine", "falcon",
+	"feather", "fence", "ferry", "fiddle", "flame", "flute", "forest",
+	"fossil", "fox", "frost", "galaxy", "garden", "garlic", "gecko",
+	"geyser", "ginger", "giraffe", "glove", "goblet", "goose", "granite",
+	"grape", "gravel", "guitar", "hammer", "harbor", "harp", "hazel",
+	"helmet", "heron", "hockey", "honey", "hornet", "hotel", "iceberg",
+	"igloo", "island", "ivory", "jacket", "jaguar", "jelly", "jigsaw",
+	"jungle", "kayak", "kettle", "kiwi", "koala", "ladder", "lagoon",
+	"lantern", "laptop", "lemon", "lentil", "lily", "lion", "lizard",
+	"locket", "lotus", "magnet", "mango", "maple", "marble", "meadow",
+	"melon", "meteor", "mirror", "mitten", "monkey", "moose", "mosaic",
+	"motor", "muffin", "napkin", "nectar", "needle", "nickel", "noodle",
+	"nutmeg", "oasis", "ocean", "olive", "onion", "opera", "orange",
+	"orbit", "orchid", "otter", "oyster", "paddle", "palace", "panda",
+	"parrot", "peach", "pearl", "pebble", "pencil", "pepper", "piano",
+	"pickle", "pigeon", "pillow", "pirate", "planet", "plum", "pocket",
+	"pony", "potato", "pumpkin", "puzzle", "quartz", "quill", "rabbit",
+	"radar", "radish", "raven", "ribbon", "river", "robot", "rocket",
+	"saddle", "salmon", "sandal", "satin", "scarf", "shadow", "shovel",
+	"silver", "skate", "sloth", "spider", "sponge", "squid", "statue",
+	"summit", "sunset", "swan", "tablet", "tango", "teapot", "temple",
+	"tiger", "tomato", "tulip", "turtle", "valley", "velvet", "violin",
+	"waffle", "walnut", "walrus", "whale", "willow", "window", "wizard",
+	"yogurt", "zebra", "zipper",
+}
+
+var fingerprintWordIndex = make(map[string]byte, len(fingerprintWords))
+
+func init() {
+	for i, w := range fingerprintWords {
+		fingerprintWordIndex[w] = byte(i)
+	}
+}
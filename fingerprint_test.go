@@ -0,0 +1,110 @@
+package otr4
+
+import (
+	"strings"
+
+	. "gopkg.in/check.v1"
+)
+
+func testFingerprint(c *C) Fingerprint {
+	pub, _, err := deserializePrivateKey(decodeHex(c, rfc8032Vectors[0].secret))
+	c.Assert(err, IsNil)
+
+	return pub.fingerprint()
+}
+
+func (s *OTR4Suite) Test_FingerprintIsTheHashOfTheSerializedKey(c *C) {
+	pub, _, err := deserializePrivateKey(decodeHex(c, rfc8032Vectors[0].secret))
+	c.Assert(err, IsNil)
+
+	fp := pub.fingerprint()
+
+	c.Assert(fp[:], DeepEquals, kdf(usageFingerprint, fingerprintBytes, pub.serialize()))
+
+	other := generateTestKeyPair(c)
+	c.Assert(other.pub.fingerprint(), Not(Equals), fp)
+}
+
+func (s *OTR4Suite) Test_FingerprintWordListIsUnique(c *C) {
+	c.Assert(fingerprintWordIndex, HasLen, 256)
+}
+
+func (s *OTR4Suite) Test_FingerprintHexFormat(c *C) {
+	fp := testFingerprint(c)
+
+	str := fp.String()
+	groups := strings.Split(str, " ")
+	c.Assert(groups, HasLen, 14)
+	c.Assert(str, Equals, strings.ToUpper(str))
+
+	parsed, err := ParseFingerprint(str)
+	c.Assert(err, IsNil)
+	c.Assert(parsed, Equals, fp)
+
+	parsed, err = ParseFingerprint(strings.ToLower(strings.Join(groups, "\n")))
+	c.Assert(err, IsNil)
+	c.Assert(parsed, Equals, fp)
+}
+
+func (s *OTR4Suite) Test_FingerprintWordsFormat(c *C) {
+	fp := testFingerprint(c)
+
+	words := fp.Words()
+	c.Assert(strings.Fields(words), HasLen, fingerprintBytes)
+
+	parsed, err := ParseFingerprintWords(words)
+	c.Assert(err, IsNil)
+	c.Assert(parsed, Equals, fp)
+
+	parsed, err = ParseFingerprintWords(strings.ToUpper(strings.Replace(words, " ", "-", -1)))
+	c.Assert(err, IsNil)
+	c.Assert(parsed, Equals, fp)
+}
+
+func (s *OTR4Suite) Test_FingerprintQRFormat(c *C) {
+	fp := testFingerprint(c)
+
+	payload := fp.QRPayload()
+	c.Assert(strings.HasPrefix(payload, "OTR4:"), Equals, true)
+	c.Assert(strings.Trim(payload, "ABCDEFGHIJKLMNOPQRSTUVWXYZ234567:"), Equals, "")
+
+	parsed, err := ParseFingerprintQR(payload)
+	c.Assert(err, IsNil)
+	c.Assert(parsed, Equals, fp)
+}
+
+func (s *OTR4Suite) Test_ParseFingerprintRejectsInvalidInput(c *C) {
+	fp := testFingerprint(c)
+
+	_, err := ParseFingerprint(fp.String()[:len(fp.String())-2])
+	c.Assert(err, Equals, errInvalidFingerprint)
+
+	_, err = ParseFingerprint("zz")
+	c.Assert(err, Equals, errInvalidFingerprint)
+
+	_, err = ParseFingerprintWords(fp.Words() + " acid")
+	c.Assert(err, Equals, errInvalidFingerprint)
+
+	_, err = ParseFingerprintWords("notaword")
+	c.Assert(err, Equals, errInvalidFingerprint)
+
+	_, err = ParseFingerprintQR(fp.QRPayload()[1:])
+	c.Assert(err, Equals, errInvalidFingerprint)
+
+	_, err = ParseFingerprintQR("OTR4:!!")
+	c.Assert(err, Equals, errInvalidFingerprint)
+}
+
+func (s *OTR4Suite) Test_ConversationFingerprints(c *C) {
+	alice, bob := establishSession(c)
+
+	ours, err := alice.OurFingerprint()
+	c.Assert(err, IsNil)
+
+	theirs, ok := bob.TheirFingerprint()
+	c.Assert(ok, Equals, true)
+	c.Assert(theirs, Equals, ours)
+
+	_, ok = (&Conversation{}).TheirFingerprint()
+	c.Assert(ok, Equals, false)
+}
@@ -0,0 +1,184 @@
+package otr4
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+var fragmentPrefix = []byte("?OTR|")
+
+const (
+	// fragmentOverhead is the length of everything but the piece in a
+	// fragment: ?OTR|sender|receiver,k,n,piece,
+	fragmentOverhead = 5 + 8 + 1 + 8 + 1 + 5 + 1 + 5 + 1 + 1
+	maxFragments     = 65535
+
+	// fragmentTimeout is how long a partially received message is kept
+	// while waiting for its next fragment
+	fragmentTimeout = 2 * time.Minute
+)
+
+type fragment struct {
+	sender   uint32
+	receiver uint32
+	k, n     uint16
+	piece    []byte
+}
+
+func (f fragment) serialize() []byte {
+	return []byte(fmt.Sprintf("?OTR|%08x|%08x,%05d,%05d,%s,", f.sender, f.receiver, f.k, f.n, f.piece))
+}
+
+// fragmentMessage splits an encoded message into fragments that are at most
+// size bytes long. A size of zero means the message is never split.
+func fragmentMessage(msg []byte, size int, sender, receiver uint32) ([][]byte, error) {
+	if size <= 0 || len(msg) <= size {
+		return [][]byte{msg}, nil
+	}
+
+	pieceLen := size - fragmentOverhead
+	if pieceLen <= 0 {
+		return nil, errFragmentSizeTooSmall
+	}
+
+	n := (len(msg) + pieceLen - 1) / pieceLen
+	if n > maxFragments {
+		return nil, errFragmentSizeTooSmall
+	}
+
+	out := make([][]byte, 0, n)
+	for k := 1; k <= n; k++ {
+		end := k * pieceLen
+		if end > len(msg) {
+			end = len(msg)
+		}
+
+		f := fragment{
+			sender:   sender,
+			receiver: receiver,
+			k:        uint16(k),
+			n:        uint16(n),
+			piece:    msg[(k-1)*pieceLen : end],
+		}
+		out = append(out, f.serialize())
+	}
+
+	return out, nil
+}
+
+func parseFragment(msg []byte) (fragment, error) {
+	f := fragment{}
+
+	if !bytes.HasPrefix(msg, fragmentPrefix) || !bytes.HasSuffix(msg, []byte(",")) {
+		return f, errInvalidFragment
+	}
+
+	parts := bytes.Split(msg[len(fragmentPrefix):len(msg)-1], []byte(","))
+	if len(parts) != 4 {
+		return f, errInvalidFragment
+	}
+
+	tags := bytes.Split(parts[0], []byte("|"))
+	if len(tags) != 2 {
+		return f, errInvalidFragment
+	}
+
+	sender, err1 := strconv.ParseUint(string(tags[0]), 16, 32)
+	receiver, err2 := strconv.ParseUint(string(tags[1]), 16, 32)
+	k, err3 := strconv.ParseUint(string(parts[1]), 10, 16)
+	n, err4 := strconv.ParseUint(string(parts[2]), 10, 16)
+	if firstError(err1, err2, err3, err4) != nil {
+		return f, errInvalidFragment
+	}
+
+	if k == 0 || n == 0 || k > n || len(parts[3]) == 0 {
+		return f, errInvalidFragment
+	}
+
+	f.sender = uint32(sender)
+	f.receiver = uint32(receiver)
+	f.k = uint16(k)
+	f.n = uint16(n)
+	f.piece = parts[3]
+
+	return f, nil
+}
+
+type partialMessage struct {
+	k, n     uint16
+	data     []byte
+	received time.Time
+}
+
+// reassembler joins fragments back into messages. Fragments are kept apart
+// by their sender instance tag, so fragments of messages sent by different
+// instances can be interleaved. Fragments of a message must arrive in order;
+// a fragment out of sequence discards what was received before.
+type reassembler struct {
+	partial map[uint32]*partialMessage
+}
+
+// receive adds the fragment and returns the complete message, if this was
+// its last fragment
+func (r *reassembler) receive(f fragment, now time.Time) []byte {
+	r.expire(now)
+
+	if r.partial == nil {
+		r.partial = make(map[uint32]*partialMessage)
+	}
+
+	p, ok := r.partial[f.sender]
+	switch {
+	case f.k == 1:
+		p = &partialMessage{n: f.n}
+		r.partial[f.sender] = p
+	case !ok || f.n != p.n || f.k != p.k+1:
+		delete(r.partial, f.sender)
+		return nil
+	}
+
+	p.k = f.k
+	p.data = append(p.data, f.piece...)
+	p.received = now
+
+	if p.k < p.n {
+		return nil
+	}
+
+	delete(r.partial, f.sender)
+	return p.data
+}
+
+// expire discards partial messages that have not received a fragment for
+// too long
+func (r *reassembler) expire(now time.Time) {
+	for sender, p := range r.partial {
+		if now.Sub(p.received) > fragmentTimeout {
+			delete(r.partial, sender)
+		}
+	}
+}
+
+func (c *Conversation) receiveFragment(wire []byte) ([]byte, error) {
+	f, err := parseFragment(wire)
+	if err != nil {
+		return nil, err
+	}
+
+	if !isValidInstanceTag(f.sender) {
+		return nil, errInvalidInstanceTag
+	}
+
+	if f.receiver != 0 && c.instanceTag != 0 && f.receiver != c.instanceTag {
+		return nil, nil
+	}
+
+	return c.fragments.receive(f, time.Now()), nil
+}
+
+// toSend encodes the message and splits it to fit FragmentSize
+func (c *Conversation) toSend(msg []byte) ([][]byte, error) {
+	return fragmentMessage(encodeMessage(msg), c.FragmentSize, c.instanceTag, c.theirInstanceTag)
+}
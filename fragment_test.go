@@ -0,0 +1,131 @@
+package otr4
+
+import (
+	"bytes"
+	"time"
+
+	. "gopkg.in/check.v1"
+)
+
+func (s *OTR4Suite) Test_FragmentSerialization(c *C) {
+	f := fragment{sender: 0x100, receiver: 0xABCDEF01, k: 2, n: 3, piece: []byte("piece")}
+
+	c.Assert(string(f.serialize()), Equals, "?OTR|00000100|abcdef01,00002,00003,piece,")
+	c.Assert(len(f.serialize()), Equals, fragmentOverhead+len(f.piece))
+
+	parsed, err := parseFragment(f.serialize())
+	c.Assert(err, IsNil)
+	c.Assert(parsed, DeepEquals, f)
+}
+
+func (s *OTR4Suite) Test_ParseFragmentRejectsInvalidFragments(c *C) {
+	invalid := []string{
+		"?OTR|00000100|00000200,00001,00002,piece",
+		"?OTR|00000100,00001,00002,piece,",
+		"?OTR|00000100|00000200,00001,00002,pie,ce,",
+		"?OTR|zzzzzzzz|00000200,00001,00002,piece,",
+		"?OTR|00000100|00000200,00000,00002,piece,",
+		"?OTR|00000100|00000200,00003,00002,piece,",
+		"?OTR|00000100|00000200,00001,00002,,",
+	}
+
+	for _, f := range invalid {
+		_, err := parseFragment([]byte(f))
+		c.Assert(err, Equals, errInvalidFragment, Commentf("%s", f))
+	}
+}
+
+func (s *OTR4Suite) Test_FragmentMessage(c *C) {
+	msg := []byte("?OTR:" + string(bytes.Repeat([]byte("A"), 100)) + ".")
+
+	fragments, err := fragmentMessage(msg, fragmentOverhead+30, 1, 2)
+	c.Assert(err, IsNil)
+	c.Assert(fragments, HasLen, 4)
+
+	r := &reassembler{}
+	now := time.Now()
+	for i, f := range fragments {
+		c.Assert(len(f) <= fragmentOverhead+30, Equals, true)
+
+		parsed, err := parseFragment(f)
+		c.Assert(err, IsNil)
+
+		out := r.receive(parsed, now)
+		if i < len(fragments)-1 {
+			c.Assert(out, IsNil)
+		} else {
+			c.Assert(out, DeepEquals, msg)
+		}
+	}
+}
+
+func (s *OTR4Suite) Test_FragmentMessageDoesNotSplitShortMessages(c *C) {
+	msg := []byte("?OTR:AAAA.")
+
+	fragments, err := fragmentMessage(msg, len(msg), 1, 2)
+	c.Assert(err, IsNil)
+	c.Assert(fragments, DeepEquals, [][]byte{msg})
+
+	fragments, err = fragmentMessage(msg, 0, 1, 2)
+	c.Assert(err, IsNil)
+	c.Assert(fragments, DeepEquals, [][]byte{msg})
+
+	long := bytes.Repeat([]byte("A"), fragmentOverhead+1)
+	_, err = fragmentMessage(long, fragmentOverhead, 1, 2)
+	c.Assert(err, Equals, errFragmentSizeTooSmall)
+}
+
+func (s *OTR4Suite) Test_ReassemblerKeepsInstancesApart(c *C) {
+	r := &reassembler{}
+	now := time.Now()
+
+	c.Assert(r.receive(fragment{sender: 1, k: 1, n: 2, piece: []byte("a1")}, now), IsNil)
+	c.Assert(r.receive(fragment{sender: 2, k: 1, n: 2, piece: []byte("b1")}, now), IsNil)
+	c.Assert(r.receive(fragment{sender: 2, k: 2, n: 2, piece: []byte("b2")}, now), DeepEquals, []byte("b1b2"))
+	c.Assert(r.receive(fragment{sender: 1, k: 2, n: 2, piece: []byte("a2")}, now), DeepEquals, []byte("a1a2"))
+}
+
+func (s *OTR4Suite) Test_ReassemblerDiscardsFragmentsOutOfSequence(c *C) {
+	r := &reassembler{}
+	now := time.Now()
+
+	c.Assert(r.receive(fragment{sender: 1, k: 1, n: 3, piece: []byte("a1")}, now), IsNil)
+	c.Assert(r.receive(fragment{sender: 1, k: 3, n: 3, piece: []byte("a3")}, now), IsNil)
+	c.Assert(r.receive(fragment{sender: 1, k: 2, n: 3, piece: []byte("a2")}, now), IsNil)
+	c.Assert(r.partial, HasLen, 0)
+
+	c.Assert(r.receive(fragment{sender: 1, k: 1, n: 2, piece: []byte("x1")}, now), IsNil)
+	c.Assert(r.receive(fragment{sender: 1, k: 1, n: 2, piece: []byte("y1")}, now), IsNil)
+	c.Assert(r.receive(fragment{sender: 1, k: 2, n: 2, piece: []byte("y2")}, now), DeepEquals, []byte("y1y2"))
+}
+
+func (s *OTR4Suite) Test_ReassemblerDiscardsStalePartialMessages(c *C) {
+	r := &reassembler{}
+	now := time.Now()
+
+	c.Assert(r.receive(fragment{sender: 1, k: 1, n: 2, piece: []byte("a1")}, now), IsNil)
+	c.Assert(r.receive(fragment{sender: 2, k: 1, n: 2, piece: []byte("b1")}, now), IsNil)
+
+	later := now.Add(fragmentTimeout + time.Second)
+	c.Assert(r.receive(fragment{sender: 1, k: 2, n: 2, piece: []byte("a2")}, later), IsNil)
+	c.Assert(r.partial, HasLen, 0)
+}
+
+func (s *OTR4Suite) Test_SendAndReceiveFragmentedMessages(c *C) {
+	alice, bob := establishSession(c)
+	bob.FragmentSize = 100
+
+	toSend, err := bob.Send([]byte("a message too long to fit in a single fragment"))
+	c.Assert(err, IsNil)
+	c.Assert(len(toSend) > 1, Equals, true)
+
+	var plain []byte
+	for _, f := range toSend {
+		c.Assert(len(f) <= 100, Equals, true)
+
+		plain, _, err = alice.Receive(f)
+		c.Assert(err, IsNil)
+	}
+
+	c.Assert(plain, DeepEquals, []byte("a message too long to fit in a single fragment"))
+}
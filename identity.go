@@ -0,0 +1,86 @@
+package otr4
+
+import (
+	"crypto/rand"
+	"io"
+)
+
+// Identity is the long-term key a client is known by, along with the
+// instance tag and client profile saved with it. It is kept across restarts
+// in a key file, and can be shared by all the conversations of an account.
+type Identity struct {
+	// Account and Protocol are saved in the key file, to tell the keys of
+	// different accounts apart
+	Account  string
+	Protocol string
+
+	keys        *keyPair
+	instanceTag uint32
+	profile     *userProfile
+}
+
+// GenerateIdentity creates a new long-term key and instance tag
+func GenerateIdentity(rand io.Reader) (*Identity, error) {
+	pub, priv, err := generateKeys(rand)
+	if err != nil {
+		return nil, err
+	}
+
+	tag, err := generateInstanceTag(rand)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Identity{keys: &keyPair{pub: *pub, priv: *priv}, instanceTag: tag}, nil
+}
+
+// LoadIdentity reads an identity from a key file. The passphrase is only
+// needed if the file is encrypted.
+func LoadIdentity(path string, passphrase []byte) (*Identity, error) {
+	k, err := loadKeyFile(path, passphrase)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Identity{
+		Account:     k.account,
+		Protocol:    k.protocol,
+		keys:        k.keys,
+		instanceTag: k.instanceTag,
+		profile:     k.profile,
+	}, nil
+}
+
+// Save writes the identity to a key file, encrypted under the passphrase
+// if one is given
+func (id *Identity) Save(path string, passphrase []byte) error {
+	k := &keyFile{
+		account:     id.Account,
+		protocol:    id.Protocol,
+		keys:        id.keys,
+		instanceTag: id.instanceTag,
+		profile:     id.profile,
+	}
+
+	return saveKeyFile(path, k, rand.Reader, passphrase)
+}
+
+// ParseIdentityPEM reads the long-term key from a PKCS#8 PEM block
+func ParseIdentityPEM(data []byte) (*Identity, error) {
+	pub, priv, err := privateKeyFromPEM(data)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Identity{keys: &keyPair{pub: *pub, priv: *priv}}, nil
+}
+
+// MarshalPEM encodes the long-term key as a PKCS#8 PEM block
+func (id *Identity) MarshalPEM() ([]byte, error) {
+	return privateKeyToPEM(&id.keys.priv)
+}
+
+// Fingerprint returns the fingerprint of the long-term public key
+func (id *Identity) Fingerprint() Fingerprint {
+	return id.keys.pub.fingerprint()
+}
@@ -0,0 +1,62 @@
+package otr4
+
+import (
+	"crypto/rand"
+	"path/filepath"
+
+	. "gopkg.in/check.v1"
+)
+
+func (s *OTR4Suite) Test_IdentityIsKeptAcrossSessions(c *C) {
+	id, err := GenerateIdentity(rand.Reader)
+	c.Assert(err, IsNil)
+	id.Account, id.Protocol = "alice@example.org", "xmpp"
+
+	path := filepath.Join(c.MkDir(), "otr4.key")
+	c.Assert(id.Save(path, []byte("passphrase")), IsNil)
+
+	for i := 0; i < 2; i++ {
+		loaded, err := LoadIdentity(path, []byte("passphrase"))
+		c.Assert(err, IsNil)
+		c.Assert(loaded.Account, Equals, "alice@example.org")
+		c.Assert(loaded.Protocol, Equals, "xmpp")
+
+		alice, bob := &Conversation{Identity: loaded}, &Conversation{}
+		establishSessionBetween(c, alice, bob)
+
+		fp, err := alice.OurFingerprint()
+		c.Assert(err, IsNil)
+		c.Assert(fp, Equals, id.Fingerprint())
+
+		theirs, ok := bob.TheirFingerprint()
+		c.Assert(ok, Equals, true)
+		c.Assert(theirs, Equals, id.Fingerprint())
+		c.Assert(bob.theirInstanceTag, Equals, id.instanceTag)
+
+		toSend, err := bob.Send([]byte("hi alice"))
+		c.Assert(err, IsNil)
+		plain, _, err := alice.Receive(toSend[0])
+		c.Assert(err, IsNil)
+		c.Assert(plain, DeepEquals, []byte("hi alice"))
+	}
+}
+
+func (s *OTR4Suite) Test_IdentityFromPEM(c *C) {
+	id, err := GenerateIdentity(rand.Reader)
+	c.Assert(err, IsNil)
+
+	data, err := id.MarshalPEM()
+	c.Assert(err, IsNil)
+
+	imported, err := ParseIdentityPEM(data)
+	c.Assert(err, IsNil)
+	c.Assert(imported.Fingerprint(), Equals, id.Fingerprint())
+
+	alice := &Conversation{Identity: imported}
+	fp, err := alice.OurFingerprint()
+	c.Assert(err, IsNil)
+	c.Assert(fp, Equals, id.Fingerprint())
+
+	_, err = ParseIdentityPEM([]byte("not a key"))
+	c.Assert(err, Equals, errInvalidPEM)
+}
@@ -0,0 +1,231 @@
+package otr4
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// minInstanceTag is the smallest valid instance tag. Smaller values are
+// reserved by the protocol.
+const minInstanceTag = 0x100
+
+func isValidInstanceTag(tag uint32) bool {
+	return tag >= minInstanceTag
+}
+
+// generateInstanceTag reads random instance tags until one is valid
+func generateInstanceTag(rand io.Reader) (uint32, error) {
+	var b [4]byte
+
+	for {
+		if _, err := io.ReadFull(rand, b[:]); err != nil {
+			return 0, notEnoughEntropy
+		}
+
+		_, tag, _ := extractWord32(b[:])
+		if isValidInstanceTag(tag) {
+			return tag, nil
+		}
+	}
+}
+
+// InstanceTagStore keeps the instance tag of a client across restarts, so
+// peers can keep telling its sessions apart from those of other clients
+// logged into the same account.
+type InstanceTagStore interface {
+	// LoadInstanceTag returns the stored tag, or zero if there is none
+	LoadInstanceTag() (uint32, error)
+	// SaveInstanceTag stores a newly generated tag
+	SaveInstanceTag(tag uint32) error
+}
+
+// InstanceTagFile is an InstanceTagStore backed by a file in the format of
+// libotr's instag file: one line per account, holding the account name,
+// the protocol and the tag in hex, separated by tabs. The tags of other
+// accounts are kept when saving.
+type InstanceTagFile struct {
+	Path     string
+	Account  string
+	Protocol string
+}
+
+type instanceTagEntry struct {
+	account  string
+	protocol string
+	tag      uint32
+}
+
+// LoadInstanceTag implements InstanceTagStore
+func (f *InstanceTagFile) LoadInstanceTag() (uint32, error) {
+	entries, err := f.read()
+	if err != nil {
+		return 0, err
+	}
+
+	for _, e := range entries {
+		if e.account == f.Account && e.protocol == f.Protocol {
+			return e.tag, nil
+		}
+	}
+
+	return 0, nil
+}
+
+// SaveInstanceTag implements InstanceTagStore
+func (f *InstanceTagFile) SaveInstanceTag(tag uint32) error {
+	if !isValidInstanceTag(tag) {
+		return errInvalidInstanceTag
+	}
+
+	entries, err := f.read()
+	if err != nil {
+		return err
+	}
+
+	found := false
+	for i, e := range entries {
+		if e.account == f.Account && e.protocol == f.Protocol {
+			entries[i].tag = tag
+			found = true
+		}
+	}
+
+	if !found {
+		entries = append(entries, instanceTagEntry{f.Account, f.Protocol, tag})
+	}
+
+	return replaceFile(f.Path, writeInstanceTags(entries))
+}
+
+func (f *InstanceTagFile) read() ([]instanceTagEntry, error) {
+	bs, err := os.ReadFile(f.Path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return parseInstanceTags(bs)
+}
+
+func parseInstanceTags(bs []byte) ([]instanceTagEntry, error) {
+	var entries []instanceTagEntry
+
+	s := bufio.NewScanner(bytes.NewReader(bs))
+	for s.Scan() {
+		line := s.Text()
+		if len(strings.TrimSpace(line)) == 0 || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.Split(line, "\t")
+		if len(fields) != 3 {
+			return nil, errInvalidInstanceTagFile
+		}
+
+		tag, err := strconv.ParseUint(fields[2], 16, 32)
+		if err != nil || !isValidInstanceTag(uint32(tag)) {
+			return nil, errInvalidInstanceTagFile
+		}
+
+		entries = append(entries, instanceTagEntry{fields[0], fields[1], uint32(tag)})
+	}
+
+	if s.Err() != nil {
+		return nil, errInvalidInstanceTagFile
+	}
+
+	return entries, nil
+}
+
+func writeInstanceTags(entries []instanceTagEntry) []byte {
+	var out bytes.Buffer
+	for _, e := range entries {
+		fmt.Fprintf(&out, "%s\t%s\t%08x\n", e.account, e.protocol, e.tag)
+	}
+
+	return out.Bytes()
+}
+
+// ourInstanceTag returns the instance tag of this client, loading it from
+// the store or the identity, or generating and saving a new one the first
+// time
+func (c *Conversation) ourInstanceTag() (uint32, error) {
+	if c.instanceTag != 0 {
+		return c.instanceTag, nil
+	}
+
+	if c.InstanceTags != nil {
+		tag, err := c.InstanceTags.LoadInstanceTag()
+		if err != nil {
+			return 0, err
+		}
+
+		if isValidInstanceTag(tag) {
+			c.instanceTag = tag
+			return tag, nil
+		}
+	}
+
+	if c.Identity != nil && isValidInstanceTag(c.Identity.instanceTag) {
+		c.instanceTag = c.Identity.instanceTag
+		return c.instanceTag, nil
+	}
+
+	tag, err := generateInstanceTag(c.rand())
+	if err != nil {
+		return 0, err
+	}
+
+	if c.InstanceTags != nil {
+		if err := c.InstanceTags.SaveInstanceTag(tag); err != nil {
+			return 0, err
+		}
+	}
+
+	c.instanceTag = tag
+	return tag, nil
+}
+
+// acceptInstanceTags checks the instance tags of a received message. It
+// returns false for messages meant for another instance of this client,
+// or sent by another instance of the peer, which should be ignored.
+func (c *Conversation) acceptInstanceTags(h messageHeader) (bool, error) {
+	if !isValidInstanceTag(h.sender) {
+		return false, errInvalidInstanceTag
+	}
+
+	// only an identity message can be sent before the peer knows our tag
+	if h.receiver == 0 {
+		switch h.typ {
+		case authRMsgType, authIMsgType, dataMsgType:
+			return false, errInvalidInstanceTag
+		}
+
+		return true, nil
+	}
+
+	if !isValidInstanceTag(h.receiver) {
+		return false, errInvalidInstanceTag
+	}
+
+	if c.instanceTag != 0 && h.receiver != c.instanceTag {
+		return false, nil
+	}
+
+	switch h.typ {
+	case authIMsgType, dataMsgType:
+		if c.theirInstanceTag != 0 && h.sender != c.theirInstanceTag {
+			return false, nil
+		}
+	}
+
+	return true, nil
+}
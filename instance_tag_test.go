@@ -0,0 +1,166 @@
+package otr4
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+
+	. "gopkg.in/check.v1"
+)
+
+func (s *OTR4Suite) Test_GenerateInstanceTagSkipsReservedValues(c *C) {
+	tag, err := generateInstanceTag(bytes.NewReader([]byte{
+		0x00, 0x00, 0x00, 0xff,
+		0x00, 0x00, 0x01, 0x00,
+	}))
+
+	c.Assert(err, IsNil)
+	c.Assert(tag, Equals, uint32(0x100))
+
+	_, err = generateInstanceTag(bytes.NewReader([]byte{0x00, 0x00, 0x00, 0x01}))
+	c.Assert(err, Equals, notEnoughEntropy)
+}
+
+func (s *OTR4Suite) Test_InstanceTagFile(c *C) {
+	path := filepath.Join(c.MkDir(), "instags")
+	c.Assert(os.WriteFile(path, []byte("# comment\nbob@example.org\txmpp\t0000abcd\n"), 0600), IsNil)
+
+	alice := &InstanceTagFile{Path: path, Account: "alice@example.org", Protocol: "xmpp"}
+
+	tag, err := alice.LoadInstanceTag()
+	c.Assert(err, IsNil)
+	c.Assert(tag, Equals, uint32(0))
+
+	c.Assert(alice.SaveInstanceTag(0x12345678), IsNil)
+	c.Assert(alice.SaveInstanceTag(0xff), Equals, errInvalidInstanceTag)
+
+	tag, err = alice.LoadInstanceTag()
+	c.Assert(err, IsNil)
+	c.Assert(tag, Equals, uint32(0x12345678))
+
+	bob := &InstanceTagFile{Path: path, Account: "bob@example.org", Protocol: "xmpp"}
+	tag, err = bob.LoadInstanceTag()
+	c.Assert(err, IsNil)
+	c.Assert(tag, Equals, uint32(0xabcd))
+}
+
+func (s *OTR4Suite) Test_SaveInstanceTagReplacesTheFile(c *C) {
+	dir := c.MkDir()
+	path := filepath.Join(dir, "instags")
+	old := "alice@example.org\txmpp\t00000abc\nbob@example.org\txmpp\t0000abcd\ncarol@example.org\tirc\t00001234\n"
+	c.Assert(os.WriteFile(path, []byte(old), 0644), IsNil)
+
+	alice := &InstanceTagFile{Path: path, Account: "alice@example.org", Protocol: "xmpp"}
+	c.Assert(alice.SaveInstanceTag(0x12345678), IsNil)
+
+	info, err := os.Stat(path)
+	c.Assert(err, IsNil)
+	c.Assert(info.Mode().Perm(), Equals, os.FileMode(0600))
+
+	entries, err := os.ReadDir(dir)
+	c.Assert(err, IsNil)
+	c.Assert(entries, HasLen, 1)
+
+	tags := map[string]uint32{}
+	for _, f := range []*InstanceTagFile{
+		alice,
+		{Path: path, Account: "bob@example.org", Protocol: "xmpp"},
+		{Path: path, Account: "carol@example.org", Protocol: "irc"},
+	} {
+		tag, err := f.LoadInstanceTag()
+		c.Assert(err, IsNil)
+		tags[f.Account] = tag
+	}
+
+	c.Assert(tags, DeepEquals, map[string]uint32{
+		"alice@example.org": 0x12345678,
+		"bob@example.org":   0xabcd,
+		"carol@example.org": 0x1234,
+	})
+}
+
+func (s *OTR4Suite) Test_InstanceTagFileThatDoesNotExist(c *C) {
+	f := &InstanceTagFile{Path: filepath.Join(c.MkDir(), "instags")}
+
+	tag, err := f.LoadInstanceTag()
+
+	c.Assert(err, IsNil)
+	c.Assert(tag, Equals, uint32(0))
+}
+
+func (s *OTR4Suite) Test_ParseInstanceTagsRejectsInvalidLines(c *C) {
+	for _, l := range []string{"alice\txmpp", "alice\txmpp\tzz", "alice\txmpp\t000000ff"} {
+		_, err := parseInstanceTags([]byte(l))
+		c.Assert(err, Equals, errInvalidInstanceTagFile, Commentf("line %q", l))
+	}
+}
+
+type memoryInstanceTagStore struct {
+	tag   uint32
+	saves int
+}
+
+func (m *memoryInstanceTagStore) LoadInstanceTag() (uint32, error) {
+	return m.tag, nil
+}
+
+func (m *memoryInstanceTagStore) SaveInstanceTag(tag uint32) error {
+	m.tag = tag
+	m.saves++
+	return nil
+}
+
+func (s *OTR4Suite) Test_OurInstanceTagIsKeptInTheStore(c *C) {
+	store := &memoryInstanceTagStore{}
+
+	first := &Conversation{InstanceTags: store}
+	tag, err := first.ourInstanceTag()
+	c.Assert(err, IsNil)
+	c.Assert(isValidInstanceTag(tag), Equals, true)
+	c.Assert(store.tag, Equals, tag)
+
+	restarted := &Conversation{InstanceTags: store}
+	tag2, err := restarted.ourInstanceTag()
+	c.Assert(err, IsNil)
+	c.Assert(tag2, Equals, tag)
+	c.Assert(store.saves, Equals, 1)
+}
+
+func (s *OTR4Suite) Test_AcceptInstanceTags(c *C) {
+	conv := &Conversation{instanceTag: 0x100, theirInstanceTag: 0x200}
+
+	cases := []struct {
+		h      messageHeader
+		accept bool
+		err    error
+	}{
+		{messageHeader{typ: identityMsgType, sender: 0x300}, true, nil},
+		{messageHeader{typ: identityMsgType, sender: 0xff}, false, errInvalidInstanceTag},
+		{messageHeader{typ: dataMsgType, sender: 0x200}, false, errInvalidInstanceTag},
+		{messageHeader{typ: dataMsgType, sender: 0x200, receiver: 0x01}, false, errInvalidInstanceTag},
+		{messageHeader{typ: dataMsgType, sender: 0x200, receiver: 0x100}, true, nil},
+		{messageHeader{typ: dataMsgType, sender: 0x200, receiver: 0x101}, false, nil},
+		{messageHeader{typ: dataMsgType, sender: 0x201, receiver: 0x100}, false, nil},
+		{messageHeader{typ: authRMsgType, sender: 0x201, receiver: 0x100}, true, nil},
+	}
+
+	for i, t := range cases {
+		accept, err := conv.acceptInstanceTags(t.h)
+		c.Assert(accept, Equals, t.accept, Commentf("case %d", i))
+		c.Assert(err, Equals, t.err, Commentf("case %d", i))
+	}
+}
+
+func (s *OTR4Suite) Test_ReceiveIgnoresMessagesForAnotherInstance(c *C) {
+	alice, bob := establishSession(c)
+
+	msg, err := bob.createDataMessage([]byte("hi alice"), 0)
+	c.Assert(err, IsNil)
+
+	alice.instanceTag++
+	plain, reply, err := alice.receiveDecoded(msg)
+
+	c.Assert(err, IsNil)
+	c.Assert(plain, IsNil)
+	c.Assert(reply, IsNil)
+}
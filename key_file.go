@@ -0,0 +1,295 @@
+package otr4
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/subtle"
+	"io"
+	"os"
+	"path/filepath"
+
+	"golang.org/x/crypto/scrypt"
+	"golang.org/x/crypto/sha3"
+)
+
+var keyFileMagic = []byte("OTRv4KEY")
+
+const (
+	keyFileVersion = 0x01
+
+	keyFilePlain     = 0x00
+	keyFileEncrypted = 0x01
+
+	keyFileSaltBytes     = 16
+	keyFileChecksumBytes = 32
+
+	// scrypt parameters for new key files. They are stored in the file,
+	// so they can be raised without breaking older files.
+	keyFileScryptLogN = 15
+	keyFileScryptR    = 8
+	keyFileScryptP    = 1
+
+	// keyFileMaxScryptLogN and keyFileMaxScryptCost bound the work a key
+	// file can ask for. The cost is r*p*2^logN; scrypt needs 128 bytes of
+	// memory for each unit of r*2^logN, so memory stays under 256 MiB.
+	keyFileMaxScryptLogN = 20
+	keyFileMaxScryptCost = 1 << 21
+)
+
+// keyFile holds everything a client needs to keep its identity across
+// restarts
+type keyFile struct {
+	account     string
+	protocol    string
+	keys        *keyPair
+	instanceTag uint32
+	// profile is optional
+	profile *userProfile
+}
+
+func (k *keyFile) serializeContents() []byte {
+	out := appendData(nil, []byte(k.account))
+	out = appendData(out, []byte(k.protocol))
+	out = appendData(out, k.keys.priv.serialize())
+	out = appendWord32(out, k.instanceTag)
+
+	if k.profile != nil {
+		return appendData(out, k.profile.serialize())
+	}
+
+	return appendData(out, nil)
+}
+
+func parseKeyFileContents(bs []byte) (*keyFile, error) {
+	k := &keyFile{}
+
+	cursor, account, ok1 := extractData(bs)
+	cursor, protocol, ok2 := extractData(cursor)
+	cursor, seed, ok3 := extractData(cursor)
+	cursor, instanceTag, ok4 := extractWord32(cursor)
+	cursor, profile, ok5 := extractData(cursor)
+	if !(ok1 && ok2 && ok3 && ok4 && ok5) || len(cursor) != 0 {
+		return nil, errInvalidKeyFile
+	}
+
+	if instanceTag != 0 && !isValidInstanceTag(instanceTag) {
+		return nil, errInvalidKeyFile
+	}
+
+	k.account = string(account)
+	k.protocol = string(protocol)
+	k.instanceTag = instanceTag
+
+	pub, priv, err := deserializePrivateKey(seed)
+	if err != nil {
+		return nil, errInvalidKeyFile
+	}
+	k.keys = &keyPair{pub: *pub, priv: *priv}
+
+	if len(profile) > 0 {
+		var rest []byte
+		k.profile, rest, err = deserializeProfile(profile)
+		if err != nil || len(rest) != 0 {
+			return nil, errInvalidKeyFile
+		}
+
+		if !k.profile.pub.h.Equals(pub.h) {
+			return nil, errInvalidKeyFile
+		}
+	}
+
+	return k, nil
+}
+
+func keyFileChecksum(bs []byte) []byte {
+	out := make([]byte, keyFileChecksumBytes)
+	sha3.ShakeSum256(out, bs)
+	return out
+}
+
+// validScryptParams checks the scrypt parameters read from a key file, so
+// a crafted file cannot make loading it use unbounded time or memory
+func validScryptParams(logN, r, p int) bool {
+	if logN < 1 || logN > keyFileMaxScryptLogN || r < 1 || p < 1 {
+		return false
+	}
+
+	return uint64(r)*uint64(p)<<uint(logN) <= keyFileMaxScryptCost
+}
+
+func keyFileCipher(passphrase, salt []byte, logN, r, p int) (cipher.AEAD, error) {
+	if !validScryptParams(logN, r, p) {
+		return nil, errInvalidKeyFile
+	}
+
+	key, err := scrypt.Key(passphrase, salt, 1<<uint(logN), r, p, 32)
+	if err != nil {
+		return nil, errInvalidKeyFile
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
+
+// serialize encodes the key file. If a passphrase is given, the contents
+// are encrypted under a key derived from it; otherwise they are only
+// protected against corruption by a checksum.
+func (k *keyFile) serialize(rand io.Reader, passphrase []byte) ([]byte, error) {
+	contents := k.serializeContents()
+	header := append([]byte{}, keyFileMagic...)
+	header = append(header, keyFileVersion)
+
+	if len(passphrase) == 0 {
+		out := append(header, keyFilePlain)
+		out = appendData(out, contents)
+		return append(out, keyFileChecksum(out)...), nil
+	}
+
+	salt := make([]byte, keyFileSaltBytes)
+	if _, err := io.ReadFull(rand, salt); err != nil {
+		return nil, notEnoughEntropy
+	}
+
+	header = append(header, keyFileEncrypted, keyFileScryptLogN, keyFileScryptR, keyFileScryptP)
+	header = append(header, salt...)
+
+	aead, err := keyFileCipher(passphrase, salt, keyFileScryptLogN, keyFileScryptR, keyFileScryptP)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(rand, nonce); err != nil {
+		return nil, notEnoughEntropy
+	}
+
+	out := append(header, nonce...)
+	return aead.Seal(out, nonce, contents, header), nil
+}
+
+func parseKeyFile(bs, passphrase []byte) (*keyFile, error) {
+	if !bytes.HasPrefix(bs, keyFileMagic) || len(bs) < len(keyFileMagic)+2 {
+		return nil, errInvalidKeyFile
+	}
+
+	cursor := bs[len(keyFileMagic):]
+	if cursor[0] != keyFileVersion {
+		return nil, errInvalidKeyFile
+	}
+
+	switch cursor[1] {
+	case keyFilePlain:
+		return parsePlainKeyFile(bs)
+	case keyFileEncrypted:
+		return parseEncryptedKeyFile(bs, passphrase)
+	}
+
+	return nil, errInvalidKeyFile
+}
+
+func parsePlainKeyFile(bs []byte) (*keyFile, error) {
+	if len(bs) < keyFileChecksumBytes {
+		return nil, errCorruptKeyFile
+	}
+
+	body, checksum := bs[:len(bs)-keyFileChecksumBytes], bs[len(bs)-keyFileChecksumBytes:]
+	if subtle.ConstantTimeCompare(keyFileChecksum(body), checksum) != 1 {
+		return nil, errCorruptKeyFile
+	}
+
+	rest, contents, ok := extractData(body[len(keyFileMagic)+2:])
+	if !ok || len(rest) != 0 {
+		return nil, errInvalidKeyFile
+	}
+
+	return parseKeyFileContents(contents)
+}
+
+func parseEncryptedKeyFile(bs, passphrase []byte) (*keyFile, error) {
+	if len(passphrase) == 0 {
+		return nil, errPassphraseRequired
+	}
+
+	headerLen := len(keyFileMagic) + 2 + 3 + keyFileSaltBytes
+	if len(bs) < headerLen {
+		return nil, errCorruptKeyFile
+	}
+
+	header := bs[:headerLen]
+	params := header[len(keyFileMagic)+2:]
+	salt := params[3:]
+
+	aead, err := keyFileCipher(passphrase, salt, int(params[0]), int(params[1]), int(params[2]))
+	if err != nil {
+		return nil, err
+	}
+
+	if len(bs) < headerLen+aead.NonceSize() {
+		return nil, errCorruptKeyFile
+	}
+
+	nonce := bs[headerLen : headerLen+aead.NonceSize()]
+	contents, err := aead.Open(nil, nonce, bs[headerLen+aead.NonceSize():], header)
+	if err != nil {
+		return nil, errCorruptKeyFile
+	}
+
+	return parseKeyFileContents(contents)
+}
+
+// saveKeyFile writes the key file to path, readable only by its owner. It
+// is written to a temporary file first, which then replaces the old one,
+// so a crash while saving never leaves the identity half written.
+func saveKeyFile(path string, k *keyFile, rand io.Reader, passphrase []byte) error {
+	bs, err := k.serialize(rand, passphrase)
+	if err != nil {
+		return err
+	}
+
+	return replaceFile(path, bs)
+}
+
+// replaceFile writes bs to a temporary file next to path and renames it over
+// path, so a failed write never leaves a truncated file behind
+func replaceFile(path string, bs []byte) error {
+	// CreateTemp creates the file with mode 0600
+	f, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+
+	if err := writeAndSync(f, bs); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+
+	if err := os.Rename(f.Name(), path); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+
+	return nil
+}
+
+func writeAndSync(f *os.File, bs []byte) error {
+	_, err := f.Write(bs)
+	if err == nil {
+		err = f.Sync()
+	}
+
+	return firstError(err, f.Close())
+}
+
+func loadKeyFile(path string, passphrase []byte) (*keyFile, error) {
+	bs, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return parseKeyFile(bs, passphrase)
+}
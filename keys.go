@@ -1,6 +1,7 @@
 package otr4
 
 import (
+	"bytes"
 	"io"
 
 	"github.com/twstrike/ed448"
@@ -19,7 +20,13 @@ type publicKey struct {
 }
 
 type privateKey struct {
-	r ed448.Scalar
+	// seed is the private key as defined by RFC 8032, from which the
+	// other values are derived
+	seed []byte
+	r    ed448.Scalar
+	// prefix is the second half of the hashed seed, used to derive the
+	// nonces of signatures
+	prefix []byte
 }
 
 func isValidPublicKey(pubs ...*publicKey) bool {
@@ -31,19 +38,29 @@ func isValidPublicKey(pubs ...*publicKey) bool {
 	return true
 }
 
-// XXX: encode the priv as SCALAR
 func generateKeys(rand io.Reader) (*publicKey, *privateKey, error) {
-	pub := &publicKey{}
-	priv := &privateKey{}
-
-	privateKey := make([]byte, privateKeySize)
-	_, err := io.ReadFull(rand, privateKey[:])
+	seed := make([]byte, privateKeySize)
+	_, err := io.ReadFull(rand, seed)
 	if err != nil {
 		return nil, nil, err
 	}
 
-	digest := make([]byte, privateKeySize)
-	sha3.ShakeSum256(digest, privateKey)
+	return deriveKeys(seed)
+}
+
+// deriveKeys derives the key pair from a seed, as RFC 8032 does for Ed448
+func deriveKeys(seed []byte) (*publicKey, *privateKey, error) {
+	if len(seed) != privateKeySize {
+		return nil, nil, errInvalidLength
+	}
+
+	pub := &publicKey{}
+	priv := &privateKey{seed: append([]byte{}, seed...)}
+
+	digest := make([]byte, 2*privateKeySize)
+	sha3.ShakeSum256(digest, seed)
+	priv.prefix = digest[privateKeySize:]
+	digest = digest[:privateKeySize]
 
 	digest[0] &= -(ed448.Cofactor)
 	digest[privateKeySize-1] = 0
@@ -59,28 +76,133 @@ func generateKeys(rand io.Reader) (*publicKey, *privateKey, error) {
 	return pub, priv, nil
 }
 
+// serialize returns the seed of the private key
+func (priv *privateKey) serialize() []byte {
+	return append([]byte{}, priv.seed...)
+}
+
+// deserializePrivateKey derives the key pair from a serialized private key
+func deserializePrivateKey(ser []byte) (*publicKey, *privateKey, error) {
+	return deriveKeys(ser)
+}
+
 var pubKeyType = []byte{0x00, 0x10}
 var pubKeyTypeValue = uint16(0x0010)
 
+// lowOrderPoints are the RFC 8032 encodings of the points of order 1, 2
+// and 4. A public key that is one of them would make any shared secret
+// predictable.
+var lowOrderPoints = [][]byte{
+	// (0, 1)
+	{
+		0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+	},
+	// (0, -1)
+	{
+		0xfe, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff,
+		0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xfe, 0xff, 0xff, 0xff,
+		0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff,
+		0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x00,
+	},
+	// (1, 0)
+	{
+		0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x80,
+	},
+	// (-1, 0)
+	{
+		0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+	},
+}
+
+// isLowOrderPoint reports whether p is the identity or one of the other
+// points in lowOrderPoints, which are the points the cofactor takes to the
+// identity
+func isLowOrderPoint(p ed448.Point) bool {
+	cofactor := make([]byte, fieldBytes)
+	cofactor[0] = ed448.Cofactor
+
+	return ed448.PointScalarMul(p, ed448.NewScalar(cofactor)).Equals(ed448.NewPointFromBytes())
+}
+
 func (pub *publicKey) serialize() []byte {
 	if pub.h == nil {
 		return nil
 	}
 
-	rslt := pubKeyType
+	rslt := append([]byte{}, pubKeyType...)
 	rslt = appendPoint(rslt, pub.h)
 	return rslt
 }
 
+// deserialize parses a serialized public key that takes up the whole buffer
 func deserialize(ser []byte) (*publicKey, error) {
-	pub := &publicKey{}
-	if len(ser) < 58 {
+	pub, rest, err := deserializePublicKey(ser)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(rest) != 0 {
+		return nil, errInvalidLength
+	}
+
+	return pub, nil
+}
+
+// deserializePublicKey parses a serialized public key and returns the
+// bytes following it
+func deserializePublicKey(ser []byte) (*publicKey, []byte, error) {
+	cursor, typ, ok := extractWord16(ser)
+	if !ok {
+		return nil, nil, errInvalidLength
+	}
+
+	if typ != pubKeyTypeValue {
+		return nil, nil, errInvalidKeyType
+	}
+
+	if len(cursor) < publicKeySize {
+		return nil, nil, errInvalidLength
+	}
+
+	pub, err := decodePublicKey(cursor[:publicKeySize])
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return pub, cursor[publicKeySize:], nil
+}
+
+// decodePublicKey decodes the RFC 8032 encoding of a public key. Only the
+// canonical encoding of a point that is not of low order is accepted.
+func decodePublicKey(enc []byte) (*publicKey, error) {
+	if len(enc) != publicKeySize {
 		return nil, errInvalidLength
 	}
 
-	var err error
-	cursor := 2
-	pub.h, cursor, err = extractPoint(ser, cursor)
+	for _, p := range lowOrderPoints {
+		if bytes.Equal(enc, p) {
+			return nil, errInvalidPoint
+		}
+	}
+
+	pub := &publicKey{h: ed448.NewPointFromBytes()}
+	valid, err := pub.h.DSADecode(enc)
+	if !valid {
+		return nil, firstError(err, errInvalidPoint)
+	}
+
+	if !isValidPublicKey(pub) || !bytes.Equal(pub.h.DSAEncode(), enc) {
+		return nil, errInvalidPoint
+	}
 
-	return pub, err
+	return pub, nil
 }
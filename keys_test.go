@@ -1,6 +1,8 @@
 package otr4
 
 import (
+	"crypto/rand"
+
 	"github.com/twstrike/ed448"
 
 	. "gopkg.in/check.v1"
@@ -79,7 +81,7 @@ func (s *OTR4Suite) Test_SerializeLongTermPubKey(c *C) {
 }
 
 func (s *OTR4Suite) Test_DeserializeLongTermPubKey(c *C) {
-	pub, err := deserialize(serPubA)
+	pub, err := deserialize(tmpSerPubA)
 
 	c.Assert(pub.h.Equals(testPubA.h), DeepEquals, true)
 	c.Assert(err, IsNil)
@@ -89,3 +91,103 @@ func (s *OTR4Suite) Test_DeserializeLongTermPubKey(c *C) {
 
 	c.Assert(err, ErrorMatches, "*. invalid length")
 }
+
+func (s *OTR4Suite) Test_DeserializePublicKeyReturnsTheRest(c *C) {
+	pub := generateTestKeyPair(c).pub
+	ser := append(pub.serialize(), 0x01, 0x02)
+
+	parsed, rest, err := deserializePublicKey(ser)
+
+	c.Assert(err, IsNil)
+	c.Assert(parsed.h.Equals(pub.h), Equals, true)
+	c.Assert(rest, DeepEquals, []byte{0x01, 0x02})
+
+	_, err = deserialize(ser)
+	c.Assert(err, Equals, errInvalidLength)
+}
+
+func (s *OTR4Suite) Test_DeserializePublicKeyRejectsInvalidLengths(c *C) {
+	ser := generateTestKeyPair(c).pub.serialize()
+
+	for _, l := range []int{0, 1, 2, len(ser) - 1} {
+		_, _, err := deserializePublicKey(ser[:l])
+		c.Assert(err, Equals, errInvalidLength, Commentf("length %d", l))
+	}
+}
+
+func (s *OTR4Suite) Test_DeserializePublicKeyRejectsAnotherKeyType(c *C) {
+	ser := generateTestKeyPair(c).pub.serialize()
+	ser[1] = 0x11
+
+	_, _, err := deserializePublicKey(ser)
+
+	c.Assert(err, Equals, errInvalidKeyType)
+}
+
+func (s *OTR4Suite) Test_DeserializePublicKeyRejectsLowOrderPoints(c *C) {
+	for _, p := range lowOrderPoints {
+		_, _, err := deserializePublicKey(append(append([]byte{}, pubKeyType...), p...))
+		c.Assert(err, Equals, errInvalidPoint)
+	}
+}
+
+func (s *OTR4Suite) Test_DeserializePublicKeyRejectsNonCanonicalEncodings(c *C) {
+	// y = p + 1, which would otherwise be read as the y of the identity
+	nonCanonical := make([]byte, publicKeySize)
+	for i := 28; i < fieldBytes; i++ {
+		nonCanonical[i] = 0xff
+	}
+
+	_, err := decodePublicKey(nonCanonical)
+	c.Assert(err, NotNil)
+
+	// the unused bits of the last byte must be zero
+	enc := generateTestKeyPair(c).pub.h.DSAEncode()
+	enc[publicKeySize-1] |= 0x01
+
+	_, err = decodePublicKey(enc)
+	c.Assert(err, NotNil)
+}
+
+func (s *OTR4Suite) Test_GenerateKeysRetainsTheSeed(c *C) {
+	seed := decodeHex(c, rfc8032Vectors[0].secret)
+
+	_, priv, err := generateKeys(fixedRand(seed))
+
+	c.Assert(err, IsNil)
+	c.Assert(priv.serialize(), DeepEquals, seed)
+}
+
+func (s *OTR4Suite) Test_DeserializePrivateKey(c *C) {
+	for _, v := range rfc8032Vectors {
+		pub, priv, err := deserializePrivateKey(decodeHex(c, v.secret))
+
+		c.Assert(err, IsNil)
+		c.Assert(pub.h.DSAEncode(), DeepEquals, decodeHex(c, v.public))
+		c.Assert(priv.serialize(), DeepEquals, decodeHex(c, v.secret))
+	}
+}
+
+func (s *OTR4Suite) Test_SerializedPrivateKeysSignTheSame(c *C) {
+	pub, priv, err := generateKeys(rand.Reader)
+	c.Assert(err, IsNil)
+
+	pub2, priv2, err := deserializePrivateKey(priv.serialize())
+	c.Assert(err, IsNil)
+	c.Assert(pub2.h.Equals(pub.h), Equals, true)
+
+	sig, err := priv.Sign([]byte("hello"), nil)
+	c.Assert(err, IsNil)
+	sig2, err := priv2.Sign([]byte("hello"), nil)
+	c.Assert(err, IsNil)
+
+	c.Assert(sig2, DeepEquals, sig)
+}
+
+func (s *OTR4Suite) Test_DeserializePrivateKeyRejectsInvalidLengths(c *C) {
+	_, _, err := deserializePrivateKey(make([]byte, privateKeySize-1))
+	c.Assert(err, Equals, errInvalidLength)
+
+	_, _, err = deserializePrivateKey(make([]byte, privateKeySize+1))
+	c.Assert(err, Equals, errInvalidLength)
+}
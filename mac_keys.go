@@ -0,0 +1,63 @@
+package otr4
+
+// defaultMaxRevealedMACKeys is used when the conversation does not set one
+const defaultMaxRevealedMACKeys = 100
+
+// heartbeatMACKeys is how many MAC keys can wait to be revealed before a
+// heartbeat message is sent to reveal them, so a peer that only receives
+// does not keep them forever
+const heartbeatMACKeys = 100
+
+// macKeyStore keeps track of the MAC keys used to authenticate received
+// data messages. They are published in the next message we send, so anyone
+// could have forged the messages they authenticated. It remembers at most
+// max of the revealed keys, or defaultMaxRevealedMACKeys if max is zero;
+// the oldest are forgotten first.
+type macKeyStore struct {
+	max      int
+	pending  [][]byte
+	revealed [][]byte
+}
+
+func (s *macKeyStore) used(mac []byte) {
+	s.pending = append(s.pending, mac)
+}
+
+// needsHeartbeat reports whether enough MAC keys are pending to be revealed
+// in a message of their own
+func (s *macKeyStore) needsHeartbeat() bool {
+	return len(s.pending) >= heartbeatMACKeys
+}
+
+// reveal returns the pending MAC keys, serialized to be sent, and records
+// them as revealed
+func (s *macKeyStore) reveal() []byte {
+	var out []byte
+	for _, mac := range s.pending {
+		out = append(out, mac...)
+	}
+
+	max := s.max
+	if max <= 0 {
+		max = defaultMaxRevealedMACKeys
+	}
+
+	s.revealed = append(s.revealed, s.pending...)
+	if extra := len(s.revealed) - max; extra > 0 {
+		s.revealed = append([][]byte(nil), s.revealed[extra:]...)
+	}
+	s.pending = nil
+
+	return out
+}
+
+// RevealedMACKeys returns the MAC keys revealed in the current session, in
+// the order they were sent. Only the last MaxRevealedMACKeys are kept.
+func (c *Conversation) RevealedMACKeys() [][]byte {
+	out := make([][]byte, len(c.macKeys.revealed))
+	for i, mac := range c.macKeys.revealed {
+		out[i] = append([]byte(nil), mac...)
+	}
+
+	return out
+}
@@ -0,0 +1,154 @@
+package otr4
+
+import (
+	. "gopkg.in/check.v1"
+)
+
+func sentDataMessage(c *C, wire []byte) *dataMessage {
+	msg, err := decodeMessage(wire)
+	c.Assert(err, IsNil)
+	body, h, err := parseMessageHeader(msg)
+	c.Assert(err, IsNil)
+	c.Assert(h.typ, Equals, dataMsgType)
+
+	m := &dataMessage{}
+	c.Assert(m.deserialize(body), IsNil)
+	return m
+}
+
+func (s *OTR4Suite) Test_MACKeysOfReceivedMessagesAreRevealedInTheNextMessage(c *C) {
+	alice, bob := establishSession(c)
+
+	toSend, err := bob.Send([]byte("hi alice"))
+	c.Assert(err, IsNil)
+	_, _, err = alice.Receive(toSend[0])
+	c.Assert(err, IsNil)
+	c.Assert(alice.RevealedMACKeys(), HasLen, 0)
+
+	toSend, err = alice.Send([]byte("hi bob"))
+	c.Assert(err, IsNil)
+
+	revealed := alice.RevealedMACKeys()
+	c.Assert(revealed, HasLen, 1)
+	c.Assert(sentDataMessage(c, toSend[0]).revealedMACKeys, DeepEquals, revealed[0])
+
+	plain, _, err := bob.Receive(toSend[0])
+	c.Assert(err, IsNil)
+	c.Assert(plain, DeepEquals, []byte("hi bob"))
+
+	toSend, err = alice.Send([]byte("again"))
+	c.Assert(err, IsNil)
+	c.Assert(sentDataMessage(c, toSend[0]).revealedMACKeys, HasLen, 0)
+	c.Assert(alice.RevealedMACKeys(), HasLen, 1)
+}
+
+func (s *OTR4Suite) Test_EndRevealsTheMACKeysOfSkippedMessages(c *C) {
+	alice, bob := establishSession(c)
+
+	_, err := bob.Send([]byte("lost"))
+	c.Assert(err, IsNil)
+	toSend, err := bob.Send([]byte("delivered"))
+	c.Assert(err, IsNil)
+	_, _, err = alice.Receive(toSend[0])
+	c.Assert(err, IsNil)
+
+	toSend, err = alice.End()
+	c.Assert(err, IsNil)
+	c.Assert(toSend, HasLen, 1)
+	c.Assert(alice.IsEncrypted(), Equals, false)
+
+	m := sentDataMessage(c, toSend[0])
+	c.Assert(m.flags, Equals, flagIgnoreUnreadable)
+	c.Assert(m.revealedMACKeys, HasLen, 2*macKeyBytes)
+	c.Assert(alice.RevealedMACKeys(), HasLen, 2)
+
+	plain, reply, err := bob.Receive(toSend[0])
+	c.Assert(err, IsNil)
+	c.Assert(plain, IsNil)
+	c.Assert(reply, IsNil)
+	c.Assert(bob.IsEncrypted(), Equals, false)
+
+	_, err = bob.Send([]byte("hello?"))
+	c.Assert(err, Equals, errConversationFinished)
+
+	toSend, err = bob.End()
+	c.Assert(err, IsNil)
+	c.Assert(toSend, IsNil)
+
+	toSend, err = bob.Send([]byte("hello"))
+	c.Assert(err, IsNil)
+	c.Assert(toSend, DeepEquals, [][]byte{[]byte("hello")})
+}
+
+func (s *OTR4Suite) Test_EndInPlaintextDoesNothing(c *C) {
+	toSend, err := (&Conversation{}).End()
+
+	c.Assert(err, IsNil)
+	c.Assert(toSend, IsNil)
+}
+
+func (s *OTR4Suite) Test_OnlyTheLastRevealedMACKeysAreKept(c *C) {
+	store := macKeyStore{}
+	for i := 0; i < defaultMaxRevealedMACKeys+10; i++ {
+		store.used([]byte{byte(i)})
+	}
+
+	out := store.reveal()
+
+	c.Assert(out, HasLen, defaultMaxRevealedMACKeys+10)
+	c.Assert(store.revealed, HasLen, defaultMaxRevealedMACKeys)
+	c.Assert(store.revealed[0], DeepEquals, []byte{10})
+
+	store.used([]byte{0xff})
+	store.reveal()
+
+	c.Assert(store.revealed, HasLen, defaultMaxRevealedMACKeys)
+	c.Assert(store.revealed[defaultMaxRevealedMACKeys-1], DeepEquals, []byte{0xff})
+}
+
+func (s *OTR4Suite) Test_MaxRevealedMACKeysIsConfigurable(c *C) {
+	alice, bob := &Conversation{MaxRevealedMACKeys: 300}, &Conversation{}
+	establishSessionBetween(c, alice, bob)
+
+	for i := 0; i < 250; i++ {
+		toSend, err := bob.Send([]byte("hi alice"))
+		c.Assert(err, IsNil)
+		_, _, err = alice.Receive(toSend[0])
+		c.Assert(err, IsNil)
+	}
+
+	_, err := alice.Send([]byte("hi bob"))
+	c.Assert(err, IsNil)
+
+	c.Assert(alice.RevealedMACKeys(), HasLen, 250)
+}
+
+func (s *OTR4Suite) Test_AHeartbeatRevealsTheMACKeysOfAPeerThatOnlyReceives(c *C) {
+	alice, bob := establishSession(c)
+
+	var toSend [][]byte
+	for i := 0; i < heartbeatMACKeys; i++ {
+		msg, err := bob.Send([]byte("hi alice"))
+		c.Assert(err, IsNil)
+
+		var plain []byte
+		plain, toSend, err = alice.Receive(msg[0])
+		c.Assert(err, IsNil)
+		c.Assert(plain, DeepEquals, []byte("hi alice"))
+
+		if i < heartbeatMACKeys-1 {
+			c.Assert(toSend, IsNil)
+		}
+	}
+
+	c.Assert(toSend, HasLen, 1)
+	m := sentDataMessage(c, toSend[0])
+	c.Assert(m.flags, Equals, flagIgnoreUnreadable)
+	c.Assert(m.revealedMACKeys, HasLen, heartbeatMACKeys*macKeyBytes)
+	c.Assert(alice.macKeys.pending, HasLen, 0)
+
+	plain, reply, err := bob.Receive(toSend[0])
+	c.Assert(err, IsNil)
+	c.Assert(plain, IsNil)
+	c.Assert(reply, IsNil)
+}
@@ -0,0 +1,156 @@
+package otr4
+
+import (
+	"bytes"
+	"strings"
+)
+
+// Policy controls how a conversation offers and negotiates OTR.
+type Policy int
+
+const (
+	// AllowV4 allows OTR version 4 to be negotiated
+	AllowV4 Policy = 1 << iota
+	// SendWhitespaceTag appends a whitespace tag to the first plaintext
+	// message sent, to let the peer know we support OTR
+	SendWhitespaceTag
+	// WhitespaceStartDAKE starts the DAKE when a whitespace tag offering a
+	// version we allow is received
+	WhitespaceStartDAKE
+)
+
+// defaultPolicy is used when the conversation does not set one
+const defaultPolicy = AllowV4 | WhitespaceStartDAKE
+
+var queryPrefix = []byte("?OTRv")
+
+var (
+	whitespaceTagBase = []byte{
+		0x20, 0x09, 0x20, 0x20, 0x09, 0x09, 0x09, 0x09,
+		0x20, 0x09, 0x20, 0x09, 0x20, 0x09, 0x20, 0x20,
+	}
+	whitespaceTagV4 = []byte{0x20, 0x20, 0x09, 0x09, 0x20, 0x09, 0x20, 0x20}
+)
+
+const whitespaceTagBytes = 8
+
+// whitespaceTags maps the tag of each version to its version number
+var whitespaceTags = map[string]byte{
+	"\x20\x20\x09\x09\x20\x20\x09\x20": '2',
+	"\x20\x20\x09\x09\x20\x20\x09\x09": '3',
+	string(whitespaceTagV4):            '4',
+}
+
+func (c *Conversation) policy() Policy {
+	if c.Policy == 0 {
+		return defaultPolicy
+	}
+	return c.Policy
+}
+
+func (p Policy) has(o Policy) bool {
+	return p&o == o
+}
+
+// allowedVersions returns the versions allowed by the policy, as they
+// appear in a query message
+func (p Policy) allowedVersions() string {
+	if p.has(AllowV4) {
+		return "4"
+	}
+	return ""
+}
+
+// selectVersion returns the highest version offered by the peer that the
+// policy allows
+func selectVersion(p Policy, offered string) (byte, error) {
+	if strings.ContainsRune(offered, '4') && p.has(AllowV4) {
+		return otrVersion, nil
+	}
+
+	return 0, errInvalidVersion
+}
+
+// QueryMessage returns a message asking the peer to start an OTR
+// conversation with one of the versions we allow.
+func (c *Conversation) QueryMessage() []byte {
+	return []byte("?OTRv" + c.policy().allowedVersions() + "?")
+}
+
+// parseQueryMessage returns the versions offered in a query message
+// contained in msg
+func parseQueryMessage(msg []byte) (string, bool) {
+	i := bytes.Index(msg, queryPrefix)
+	if i == -1 {
+		return "", false
+	}
+
+	rest := msg[i+len(queryPrefix):]
+	end := bytes.IndexByte(rest, '?')
+	if end == -1 {
+		return "", false
+	}
+
+	return string(rest[:end]), true
+}
+
+func whitespaceTag(p Policy) []byte {
+	if !p.has(AllowV4) {
+		return nil
+	}
+
+	return append(append([]byte{}, whitespaceTagBase...), whitespaceTagV4...)
+}
+
+// stripWhitespaceTag removes a whitespace tag from msg. It returns the
+// message without the tag and the versions offered by it.
+func stripWhitespaceTag(msg []byte) ([]byte, string, bool) {
+	i := bytes.Index(msg, whitespaceTagBase)
+	if i == -1 {
+		return msg, "", false
+	}
+
+	var versions []byte
+	end := i + len(whitespaceTagBase)
+	for end+whitespaceTagBytes <= len(msg) {
+		v, ok := whitespaceTags[string(msg[end:end+whitespaceTagBytes])]
+		if !ok {
+			break
+		}
+
+		versions = append(versions, v)
+		end += whitespaceTagBytes
+	}
+
+	out := append(append([]byte{}, msg[:i]...), msg[end:]...)
+	return out, string(versions), true
+}
+
+// receivePlaintext handles a message that is not an encoded OTR message,
+// starting the DAKE if it is a query message or has a whitespace tag
+func (c *Conversation) receivePlaintext(msg []byte) ([]byte, []byte, error) {
+	if versions, ok := parseQueryMessage(msg); ok {
+		if _, err := selectVersion(c.policy(), versions); err != nil {
+			return nil, nil, nil
+		}
+
+		identity, err := c.startDAKE()
+		return nil, identity, err
+	}
+
+	out, versions, ok := stripWhitespaceTag(msg)
+	if !ok || !c.policy().has(WhitespaceStartDAKE) {
+		return out, nil, nil
+	}
+
+	if _, err := selectVersion(c.policy(), versions); err != nil {
+		return out, nil, nil
+	}
+
+	identity, err := c.startDAKE()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return out, identity, nil
+}
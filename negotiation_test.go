@@ -0,0 +1,108 @@
+package otr4
+
+import (
+	. "gopkg.in/check.v1"
+)
+
+func (s *OTR4Suite) Test_QueryMessage(c *C) {
+	c.Assert((&Conversation{}).QueryMessage(), DeepEquals, []byte("?OTRv4?"))
+}
+
+func (s *OTR4Suite) Test_ParseQueryMessage(c *C) {
+	versions, ok := parseQueryMessage([]byte("?OTRv34? Let's talk privately"))
+	c.Assert(ok, Equals, true)
+	c.Assert(versions, Equals, "34")
+
+	_, ok = parseQueryMessage([]byte("hello"))
+	c.Assert(ok, Equals, false)
+
+	_, ok = parseQueryMessage([]byte("?OTRv4"))
+	c.Assert(ok, Equals, false)
+}
+
+func (s *OTR4Suite) Test_SelectVersion(c *C) {
+	v, err := selectVersion(AllowV4, "34")
+	c.Assert(err, IsNil)
+	c.Assert(v, Equals, byte(otrVersion))
+
+	_, err = selectVersion(AllowV4, "23")
+	c.Assert(err, Equals, errInvalidVersion)
+
+	_, err = selectVersion(SendWhitespaceTag, "4")
+	c.Assert(err, Equals, errInvalidVersion)
+}
+
+func (s *OTR4Suite) Test_StripWhitespaceTag(c *C) {
+	v3 := []byte{0x20, 0x20, 0x09, 0x09, 0x20, 0x20, 0x09, 0x09}
+	msg := append([]byte("hello"), whitespaceTagBase...)
+	msg = append(msg, v3...)
+	msg = append(msg, whitespaceTagV4...)
+	msg = append(msg, []byte(" there")...)
+
+	out, versions, ok := stripWhitespaceTag(msg)
+
+	c.Assert(ok, Equals, true)
+	c.Assert(versions, Equals, "34")
+	c.Assert(out, DeepEquals, []byte("hello there"))
+}
+
+func (s *OTR4Suite) Test_SendAppendsTheWhitespaceTagOnce(c *C) {
+	con := &Conversation{Policy: AllowV4 | SendWhitespaceTag}
+
+	toSend, err := con.Send([]byte("hello"))
+	c.Assert(err, IsNil)
+	c.Assert(toSend, DeepEquals, [][]byte{append([]byte("hello"), whitespaceTag(AllowV4)...)})
+
+	toSend, err = con.Send([]byte("hello"))
+	c.Assert(err, IsNil)
+	c.Assert(toSend, DeepEquals, [][]byte{[]byte("hello")})
+}
+
+func (s *OTR4Suite) Test_QueryMessageStartsTheDAKE(c *C) {
+	alice, bob := &Conversation{}, &Conversation{}
+
+	plain, toSend, err := bob.Receive(alice.QueryMessage())
+	c.Assert(err, IsNil)
+	c.Assert(plain, IsNil)
+	c.Assert(toSend, HasLen, 1)
+
+	_, toSend, err = alice.Receive(toSend[0])
+	c.Assert(err, IsNil)
+	_, toSend, err = bob.Receive(toSend[0])
+	c.Assert(err, IsNil)
+	_, _, err = alice.Receive(toSend[0])
+	c.Assert(err, IsNil)
+
+	c.Assert(alice.IsEncrypted(), Equals, true)
+	c.Assert(bob.IsEncrypted(), Equals, true)
+}
+
+func (s *OTR4Suite) Test_WhitespaceTagStartsTheDAKE(c *C) {
+	alice := &Conversation{Policy: AllowV4 | SendWhitespaceTag}
+	bob := &Conversation{}
+
+	toSend, err := alice.Send([]byte("hello"))
+	c.Assert(err, IsNil)
+
+	plain, reply, err := bob.Receive(toSend[0])
+	c.Assert(err, IsNil)
+	c.Assert(plain, DeepEquals, []byte("hello"))
+	c.Assert(reply, HasLen, 1)
+}
+
+func (s *OTR4Suite) Test_WhitespaceTagIsIgnoredWhenThePolicyDoesNotStartTheDAKE(c *C) {
+	bob := &Conversation{Policy: AllowV4}
+
+	plain, reply, err := bob.Receive(append([]byte("hello"), whitespaceTag(AllowV4)...))
+	c.Assert(err, IsNil)
+	c.Assert(plain, DeepEquals, []byte("hello"))
+	c.Assert(reply, IsNil)
+}
+
+func (s *OTR4Suite) Test_QueryMessageForUnsupportedVersionsIsIgnored(c *C) {
+	plain, reply, err := (&Conversation{}).Receive([]byte("?OTRv23?"))
+
+	c.Assert(err, IsNil)
+	c.Assert(plain, IsNil)
+	c.Assert(reply, IsNil)
+}
@@ -0,0 +1,140 @@
+package otr4
+
+import (
+	"encoding/asn1"
+	"encoding/pem"
+)
+
+// oidEd448 identifies Ed448 keys, as defined by RFC 8410
+var oidEd448 = asn1.ObjectIdentifier{1, 3, 101, 113}
+
+const (
+	pemPrivateKeyType = "PRIVATE KEY"
+	pemPublicKeyType  = "PUBLIC KEY"
+)
+
+type algorithmIdentifier struct {
+	Algorithm asn1.ObjectIdentifier
+}
+
+// pkcs8 is the PKCS#8 PrivateKeyInfo structure
+type pkcs8 struct {
+	Version    int
+	Algorithm  algorithmIdentifier
+	PrivateKey []byte
+}
+
+// subjectPublicKeyInfo is the X.509 SubjectPublicKeyInfo structure
+type subjectPublicKeyInfo struct {
+	Algorithm algorithmIdentifier
+	PublicKey asn1.BitString
+}
+
+func marshalPKCS8PrivateKey(priv *privateKey) ([]byte, error) {
+	// RFC 8410 wraps the seed in an OCTET STRING inside the private key
+	// OCTET STRING
+	seed, err := asn1.Marshal(priv.serialize())
+	if err != nil {
+		return nil, err
+	}
+
+	return asn1.Marshal(pkcs8{
+		Algorithm:  algorithmIdentifier{Algorithm: oidEd448},
+		PrivateKey: seed,
+	})
+}
+
+func parsePKCS8PrivateKey(der []byte) (*publicKey, *privateKey, error) {
+	var info pkcs8
+	rest, err := asn1.Unmarshal(der, &info)
+	if err != nil || len(rest) != 0 || info.Version != 0 {
+		return nil, nil, errInvalidKeyEncoding
+	}
+
+	if !info.Algorithm.Algorithm.Equal(oidEd448) {
+		return nil, nil, errUnsupportedKeyAlgorithm
+	}
+
+	var seed []byte
+	rest, err = asn1.Unmarshal(info.PrivateKey, &seed)
+	if err != nil || len(rest) != 0 {
+		return nil, nil, errInvalidKeyEncoding
+	}
+
+	pub, priv, err := deserializePrivateKey(seed)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if !isValidPublicKey(pub) {
+		return nil, nil, errInvalidPoint
+	}
+
+	return pub, priv, nil
+}
+
+func marshalPKIXPublicKey(pub *publicKey) ([]byte, error) {
+	enc := pub.h.DSAEncode()
+
+	return asn1.Marshal(subjectPublicKeyInfo{
+		Algorithm: algorithmIdentifier{Algorithm: oidEd448},
+		PublicKey: asn1.BitString{Bytes: enc, BitLength: 8 * len(enc)},
+	})
+}
+
+func parsePKIXPublicKey(der []byte) (*publicKey, error) {
+	var info subjectPublicKeyInfo
+	rest, err := asn1.Unmarshal(der, &info)
+	if err != nil || len(rest) != 0 {
+		return nil, errInvalidKeyEncoding
+	}
+
+	if !info.Algorithm.Algorithm.Equal(oidEd448) {
+		return nil, errUnsupportedKeyAlgorithm
+	}
+
+	enc := info.PublicKey.RightAlign()
+	if len(enc) != publicKeySize || info.PublicKey.BitLength != 8*publicKeySize {
+		return nil, errInvalidLength
+	}
+
+	return decodePublicKey(enc)
+}
+
+// privateKeyToPEM encodes the private key as a PKCS#8 PEM block
+func privateKeyToPEM(priv *privateKey) ([]byte, error) {
+	der, err := marshalPKCS8PrivateKey(priv)
+	if err != nil {
+		return nil, err
+	}
+
+	return pem.EncodeToMemory(&pem.Block{Type: pemPrivateKeyType, Bytes: der}), nil
+}
+
+// publicKeyToPEM encodes the public key as a SubjectPublicKeyInfo PEM block
+func publicKeyToPEM(pub *publicKey) ([]byte, error) {
+	der, err := marshalPKIXPublicKey(pub)
+	if err != nil {
+		return nil, err
+	}
+
+	return pem.EncodeToMemory(&pem.Block{Type: pemPublicKeyType, Bytes: der}), nil
+}
+
+func privateKeyFromPEM(data []byte) (*publicKey, *privateKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != pemPrivateKeyType {
+		return nil, nil, errInvalidPEM
+	}
+
+	return parsePKCS8PrivateKey(block.Bytes)
+}
+
+func publicKeyFromPEM(data []byte) (*publicKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != pemPublicKeyType {
+		return nil, errInvalidPEM
+	}
+
+	return parsePKIXPublicKey(block.Bytes)
+}
@@ -9,7 +9,7 @@ import (
 	"github.com/twstrike/ed448"
 )
 
-func (c *conversation) rand() io.Reader {
+func (c *Conversation) rand() io.Reader {
 	if c.random != nil {
 		return c.random
 	}
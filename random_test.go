@@ -11,12 +11,12 @@ import (
 func (s *OTR4Suite) Test_Randomness(c *C) {
 	// randomness
 	r := fixedRand([]byte{0x00})
-	con := &conversation{random: r}
+	con := &Conversation{random: r}
 
 	c.Assert(con.rand(), DeepEquals, r)
 
 	// no randomness
-	con = &conversation{}
+	con = &Conversation{}
 
 	c.Assert(con.rand(), DeepEquals, rand.Reader)
 }
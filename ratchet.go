@@ -0,0 +1,241 @@
+package otr4
+
+import (
+	"io"
+	"math/big"
+
+	"github.com/twstrike/ed448"
+)
+
+// ratchetHeader carries the values the receiver of a data message needs to
+// follow the sender's ratchet
+type ratchetHeader struct {
+	ratchetID uint32
+	messageID uint32
+	// previousN is the number of messages sent in the sender's previous
+	// sending chain
+	previousN uint32
+	ecdh      ed448.Point
+	// dh is only set on every third ratchet, when the brace key is
+	// refreshed with a new Diffie-Hellman value
+	dh *big.Int
+}
+
+// ratchet implements the OTRv4 double ratchet. Every time a party sends
+// after having received from its peer, it generates a new ECDH key and
+// mixes it into the root key. Every third ratchet also generates a new
+// 3072-bit DH key, which refreshes the brace key; otherwise the brace key
+// is hashed forward.
+//
+// Keys are always replaced and never modified in place, so a clone of a
+// ratchet can be used to roll back state.
+type ratchet struct {
+	rootKey  []byte
+	braceKey []byte
+
+	ourECDH   *ecdhKeyPair
+	ourDH     *dhKeyPair
+	theirECDH ed448.Point
+	theirDH   *big.Int
+
+	// i is the id of the latest ratchet, performed by either party
+	i             uint32
+	shouldRatchet bool
+
+	sendingRatchetID uint32
+	sendingChainKey  []byte
+	j                uint32
+	pn               uint32
+
+	receivingRatchetID uint32
+	receivingChainKey  []byte
+	k                  uint32
+
+	skipped *skippedKeys
+}
+
+// newRatchet initializes the ratchet from the values agreed on during the
+// DAKE. Bob, who sent the Identity message, can start sending right away on
+// the first chain; Alice starts by ratcheting.
+func newRatchet(a *ake, sharedSecret, braceKey []byte, maxSkipped int) *ratchet {
+	r := &ratchet{
+		rootKey:   kdf(usageRootKey, 64, sharedSecret),
+		braceKey:  braceKey,
+		ourECDH:   a.ourECDH,
+		ourDH:     a.ourDH,
+		theirECDH: a.theirECDH,
+		theirDH:   a.theirDH,
+		skipped:   newSkippedKeys(maxSkipped),
+	}
+
+	chainKey := kdf(usageChainKey, 64, sharedSecret)
+	if a.weAreBob {
+		r.sendingChainKey = chainKey
+	} else {
+		r.receivingChainKey = chainKey
+		r.shouldRatchet = true
+	}
+
+	return r
+}
+
+func (r *ratchet) clone() *ratchet {
+	c := *r
+	c.skipped = r.skipped.clone()
+	return &c
+}
+
+func isDHRatchet(ratchetID uint32) bool {
+	return ratchetID%3 == 0
+}
+
+func (r *ratchet) deriveChainKey(ecdhKey []byte) ([]byte, []byte) {
+	k := kdf(usageRatchetSecret, 64, ecdhKey, r.braceKey)
+	return kdf(usageRootKey, 64, r.rootKey, k), kdf(usageChainKey, 64, r.rootKey, k)
+}
+
+func (r *ratchet) ratchetSending(rand io.Reader) error {
+	ourECDH, err := generateECDHKeyPair(rand)
+	if err != nil {
+		return err
+	}
+
+	id := r.i + 1
+	if isDHRatchet(id) {
+		ourDH, err := generateDHKeyPair(rand)
+		if err != nil {
+			return err
+		}
+
+		r.ourDH = ourDH
+		r.braceKey = kdf(usageThirdBraceKey, 32, dhSecret(ourDH.priv, r.theirDH))
+	} else {
+		r.braceKey = kdf(usageBraceKey, 32, r.braceKey)
+	}
+
+	r.ourECDH = ourECDH
+	r.rootKey, r.sendingChainKey = r.deriveChainKey(ecdhSecret(ourECDH.priv, r.theirECDH))
+
+	r.i = id
+	r.sendingRatchetID = id
+	r.pn = r.j
+	r.j = 0
+	r.shouldRatchet = false
+
+	return nil
+}
+
+func (r *ratchet) ratchetReceiving(h ratchetHeader) error {
+	if isDHRatchet(h.ratchetID) {
+		if h.dh == nil || !isGroupElement(h.dh) {
+			return errInvalidDHValue
+		}
+
+		r.theirDH = h.dh
+		r.braceKey = kdf(usageThirdBraceKey, 32, dhSecret(r.ourDH.priv, h.dh))
+	} else {
+		r.braceKey = kdf(usageBraceKey, 32, r.braceKey)
+	}
+
+	r.theirECDH = h.ecdh
+	r.rootKey, r.receivingChainKey = r.deriveChainKey(ecdhSecret(r.ourECDH.priv, h.ecdh))
+
+	r.i = h.ratchetID
+	r.receivingRatchetID = h.ratchetID
+	r.k = 0
+	r.shouldRatchet = true
+
+	return nil
+}
+
+func messageKey(chainKey []byte) ([]byte, []byte) {
+	return kdf(usageMessageKey, 64, chainKey), kdf(usageNextChainKey, 64, chainKey)
+}
+
+// sendingMessageKey ratchets if needed and returns the header and the
+// message key for the next message to be sent
+func (r *ratchet) sendingMessageKey(rand io.Reader) (ratchetHeader, []byte, error) {
+	if r.shouldRatchet {
+		if err := r.ratchetSending(rand); err != nil {
+			return ratchetHeader{}, nil, err
+		}
+	}
+
+	h := ratchetHeader{
+		ratchetID: r.sendingRatchetID,
+		messageID: r.j,
+		previousN: r.pn,
+		ecdh:      r.ourECDH.pub,
+	}
+
+	if r.sendingRatchetID != 0 && isDHRatchet(r.sendingRatchetID) {
+		h.dh = r.ourDH.pub
+	}
+
+	var mk []byte
+	mk, r.sendingChainKey = messageKey(r.sendingChainKey)
+	r.j++
+
+	return h, mk, nil
+}
+
+// receivingMessageKey follows the sender's ratchet as described by the
+// header and returns the message key for the received message. Keys for
+// messages skipped on the way are kept, so they can be decrypted when they
+// arrive later.
+func (r *ratchet) receivingMessageKey(h ratchetHeader) ([]byte, error) {
+	if h.ratchetID == r.i+1 && !r.shouldRatchet {
+		if r.receivingChainKey != nil {
+			if err := r.skipReceivingChain(h.previousN); err != nil {
+				return nil, err
+			}
+		}
+
+		if err := r.ratchetReceiving(h); err != nil {
+			return nil, err
+		}
+	}
+
+	if h.ratchetID > r.receivingRatchetID || r.receivingChainKey == nil {
+		return nil, errImpossibleToDecrypt
+	}
+
+	if h.ratchetID < r.receivingRatchetID || h.messageID < r.k {
+		mk, ok := r.skipped.take(skippedKeyID{h.ratchetID, h.messageID})
+		if !ok {
+			return nil, errReplayedMessage
+		}
+
+		return mk, nil
+	}
+
+	if err := r.skipReceivingChain(h.messageID); err != nil {
+		return nil, err
+	}
+
+	var mk []byte
+	mk, r.receivingChainKey = messageKey(r.receivingChainKey)
+	r.k++
+
+	return mk, nil
+}
+
+// skipReceivingChain stores the keys of the current receiving chain up to
+// the given message id
+func (r *ratchet) skipReceivingChain(until uint32) error {
+	if until < r.k {
+		return nil
+	}
+
+	if int(until-r.k) > r.skipped.max {
+		return errTooManySkippedMessages
+	}
+
+	for ; r.k < until; r.k++ {
+		var mk []byte
+		mk, r.receivingChainKey = messageKey(r.receivingChainKey)
+		r.skipped.store(skippedKeyID{r.receivingRatchetID, r.k}, mk)
+	}
+
+	return nil
+}
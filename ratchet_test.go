@@ -0,0 +1,161 @@
+package otr4
+
+import (
+	"crypto/rand"
+
+	. "gopkg.in/check.v1"
+)
+
+func (s *OTR4Suite) Test_RatchetBobCanSendOnTheFirstChain(c *C) {
+	alice, bob := establishSession(c)
+
+	h, mk, err := bob.ratchet.sendingMessageKey(rand.Reader)
+
+	c.Assert(err, IsNil)
+	c.Assert(h.ratchetID, Equals, uint32(0))
+	c.Assert(h.messageID, Equals, uint32(0))
+	c.Assert(h.dh, IsNil)
+
+	rmk, err := alice.ratchet.receivingMessageKey(h)
+
+	c.Assert(err, IsNil)
+	c.Assert(rmk, DeepEquals, mk)
+}
+
+func (s *OTR4Suite) Test_RatchetPingPong(c *C) {
+	alice, bob := establishSession(c)
+
+	sender, receiver := alice.ratchet, bob.ratchet
+	for i := uint32(1); i <= 7; i++ {
+		for j := uint32(0); j < 2; j++ {
+			h, mk, err := sender.sendingMessageKey(rand.Reader)
+			c.Assert(err, IsNil)
+			c.Assert(h.ratchetID, Equals, i)
+			c.Assert(h.messageID, Equals, j)
+			c.Assert(h.dh != nil, Equals, i%3 == 0)
+
+			rmk, err := receiver.receivingMessageKey(h)
+			c.Assert(err, IsNil)
+			c.Assert(rmk, DeepEquals, mk)
+		}
+
+		c.Assert(sender.braceKey, DeepEquals, receiver.braceKey)
+		sender, receiver = receiver, sender
+	}
+}
+
+func (s *OTR4Suite) Test_RatchetSkipsAheadInAChain(c *C) {
+	alice, bob := establishSession(c)
+
+	bob.ratchet.sendingMessageKey(rand.Reader)
+	bob.ratchet.sendingMessageKey(rand.Reader)
+	h, mk, _ := bob.ratchet.sendingMessageKey(rand.Reader)
+
+	rmk, err := alice.ratchet.receivingMessageKey(h)
+
+	c.Assert(err, IsNil)
+	c.Assert(rmk, DeepEquals, mk)
+	c.Assert(alice.ratchet.k, Equals, uint32(3))
+}
+
+func (s *OTR4Suite) Test_RatchetRejectsAReplayedHeader(c *C) {
+	alice, bob := establishSession(c)
+
+	h, _, _ := bob.ratchet.sendingMessageKey(rand.Reader)
+	_, err := alice.ratchet.receivingMessageKey(h)
+	c.Assert(err, IsNil)
+
+	_, err = alice.ratchet.receivingMessageKey(h)
+
+	c.Assert(err, Equals, errReplayedMessage)
+}
+
+func (s *OTR4Suite) Test_RatchetDecryptsOutOfOrderMessages(c *C) {
+	alice, bob := establishSession(c)
+
+	h0, mk0, _ := bob.ratchet.sendingMessageKey(rand.Reader)
+	h1, mk1, _ := bob.ratchet.sendingMessageKey(rand.Reader)
+	h2, mk2, _ := bob.ratchet.sendingMessageKey(rand.Reader)
+
+	rmk, err := alice.ratchet.receivingMessageKey(h2)
+	c.Assert(err, IsNil)
+	c.Assert(rmk, DeepEquals, mk2)
+	c.Assert(alice.ratchet.skipped.keys, HasLen, 2)
+
+	rmk, err = alice.ratchet.receivingMessageKey(h0)
+	c.Assert(err, IsNil)
+	c.Assert(rmk, DeepEquals, mk0)
+
+	_, err = alice.ratchet.receivingMessageKey(h0)
+	c.Assert(err, Equals, errReplayedMessage)
+
+	rmk, err = alice.ratchet.receivingMessageKey(h1)
+	c.Assert(err, IsNil)
+	c.Assert(rmk, DeepEquals, mk1)
+	c.Assert(alice.ratchet.skipped.keys, HasLen, 0)
+}
+
+func (s *OTR4Suite) Test_RatchetKeepsTheKeysOfThePreviousChain(c *C) {
+	alice, bob := establishSession(c)
+
+	h, _, _ := alice.ratchet.sendingMessageKey(rand.Reader)
+	bob.ratchet.receivingMessageKey(h)
+
+	late, lateMK, _ := alice.ratchet.sendingMessageKey(rand.Reader)
+	h, _, _ = bob.ratchet.sendingMessageKey(rand.Reader)
+	alice.ratchet.receivingMessageKey(h)
+
+	h, mk, _ := alice.ratchet.sendingMessageKey(rand.Reader)
+	c.Assert(h.ratchetID, Equals, uint32(3))
+	c.Assert(h.previousN, Equals, uint32(2))
+
+	rmk, err := bob.ratchet.receivingMessageKey(h)
+	c.Assert(err, IsNil)
+	c.Assert(rmk, DeepEquals, mk)
+
+	rmk, err = bob.ratchet.receivingMessageKey(late)
+	c.Assert(err, IsNil)
+	c.Assert(rmk, DeepEquals, lateMK)
+}
+
+func (s *OTR4Suite) Test_RatchetBoundsTheSkippedMessages(c *C) {
+	alice, bob := establishSession(c)
+	alice.ratchet.skipped.max = 2
+
+	var h ratchetHeader
+	for i := 0; i < 4; i++ {
+		h, _, _ = bob.ratchet.sendingMessageKey(rand.Reader)
+	}
+
+	_, err := alice.ratchet.receivingMessageKey(h)
+
+	c.Assert(err, Equals, errTooManySkippedMessages)
+}
+
+func (s *OTR4Suite) Test_RatchetRequiresTheDHValueEveryThirdRatchet(c *C) {
+	alice, bob := establishSession(c)
+
+	h, _, _ := alice.ratchet.sendingMessageKey(rand.Reader)
+	bob.ratchet.receivingMessageKey(h)
+	h, _, _ = bob.ratchet.sendingMessageKey(rand.Reader)
+	alice.ratchet.receivingMessageKey(h)
+
+	h, _, _ = alice.ratchet.sendingMessageKey(rand.Reader)
+	c.Assert(h.ratchetID, Equals, uint32(3))
+	c.Assert(h.dh, NotNil)
+
+	h.dh = nil
+	_, err := bob.ratchet.receivingMessageKey(h)
+
+	c.Assert(err, ErrorMatches, ".* invalid Diffie-Hellman value")
+}
+
+func (s *OTR4Suite) Test_RatchetSecretIsSeparateFromTheSharedSecret(c *C) {
+	r := &ratchet{rootKey: make([]byte, 64), braceKey: make([]byte, 32)}
+	ecdhKey := make([]byte, fieldBytes)
+
+	rootKey, _ := r.deriveChainKey(ecdhKey)
+
+	c.Assert(rootKey, DeepEquals, kdf(usageRootKey, 64, r.rootKey, kdf(usageRatchetSecret, 64, ecdhKey, r.braceKey)))
+	c.Assert(rootKey, Not(DeepEquals), kdf(usageRootKey, 64, r.rootKey, kdf(usageSharedSecret, 64, ecdhKey, r.braceKey)))
+}
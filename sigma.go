@@ -0,0 +1,229 @@
+package otr4
+
+import (
+	"io"
+
+	"github.com/twstrike/ed448"
+)
+
+// fiatShamir derives the challenge of a proof from its commitments
+type fiatShamir func(commitments []ed448.Point) ed448.Scalar
+
+// domainChallenge derives challenges with hashToScalar. The domain byte
+// keeps the challenges of different proofs apart.
+func domainChallenge(domain byte) fiatShamir {
+	return func(commitments []ed448.Point) ed448.Scalar {
+		return hashToScalar(domain, commitments...)
+	}
+}
+
+// sigmaRelation states that image is the sum of every witness multiplied
+// by its base. A nil base means the witness is not part of the relation.
+type sigmaRelation struct {
+	image ed448.Point
+	bases []ed448.Point
+}
+
+// sigmaStatement is what a sigma proof proves: knowledge of witnesses
+// that satisfy all of the relations at once
+type sigmaStatement struct {
+	relations []sigmaRelation
+	challenge fiatShamir
+}
+
+// sigmaProof is a Schnorr-style proof, made non-interactive with the
+// Fiat-Shamir heuristic. It holds the challenge and a response for every
+// witness.
+type sigmaProof struct {
+	c ed448.Scalar
+	d []ed448.Scalar
+}
+
+func newSigmaProof(c ed448.Scalar, d ...ed448.Scalar) *sigmaProof {
+	return &sigmaProof{c: c, d: d}
+}
+
+// knowledgeStatement states knowledge of the discrete log of p
+func knowledgeStatement(p ed448.Point, challenge fiatShamir) *sigmaStatement {
+	return &sigmaStatement{
+		relations: []sigmaRelation{{image: p, bases: []ed448.Point{ed448.BasePoint}}},
+		challenge: challenge,
+	}
+}
+
+func (st *sigmaStatement) witnesses() int {
+	n := 0
+	for _, r := range st.relations {
+		if len(r.bases) > n {
+			n = len(r.bases)
+		}
+	}
+	return n
+}
+
+// commitments computes, for every relation, the sum of the scalars
+// multiplied by their bases, plus the image multiplied by c. The prover
+// passes its nonces and no c, the verifier the responses and the
+// challenge.
+func (st *sigmaStatement) commitments(scalars []ed448.Scalar, c ed448.Scalar) []ed448.Point {
+	out := make([]ed448.Point, len(st.relations))
+
+	for i, r := range st.relations {
+		var t ed448.Point
+		add := func(p ed448.Point) {
+			if t == nil {
+				t = p
+			} else {
+				t.Add(t, p)
+			}
+		}
+
+		for j, base := range r.bases {
+			if base != nil {
+				add(ed448.PointScalarMul(base, scalars[j]))
+			}
+		}
+
+		if c != nil {
+			add(ed448.PointScalarMul(r.image, c))
+		}
+
+		out[i] = t
+	}
+
+	return out
+}
+
+// respond computes the responses for the challenge c. It does not modify
+// the nonces or the witnesses.
+func respond(nonces, witnesses []ed448.Scalar, c ed448.Scalar) *sigmaProof {
+	p := &sigmaProof{c: c, d: make([]ed448.Scalar, len(nonces))}
+	for j := range nonces {
+		p.d[j] = generateDZKP(nonces[j].Copy(), witnesses[j].Copy(), c)
+	}
+
+	return p
+}
+
+func randScalars(rand io.Reader, n int) ([]ed448.Scalar, error) {
+	out := make([]ed448.Scalar, n)
+	for i := range out {
+		var err error
+		if out[i], err = randScalar(rand); err != nil {
+			return nil, err
+		}
+	}
+
+	return out, nil
+}
+
+// proveWith proves the statement with the given nonces, which must never
+// be reused
+func (st *sigmaStatement) proveWith(nonces, witnesses []ed448.Scalar) *sigmaProof {
+	c := st.challenge(st.commitments(nonces, nil))
+	return respond(nonces, witnesses, c)
+}
+
+func (st *sigmaStatement) prove(rand io.Reader, witnesses ...ed448.Scalar) (*sigmaProof, error) {
+	nonces, err := randScalars(rand, len(witnesses))
+	if err != nil {
+		return nil, err
+	}
+
+	return st.proveWith(nonces, witnesses), nil
+}
+
+func (st *sigmaStatement) verify(p *sigmaProof) bool {
+	if p == nil || p.c == nil || len(p.d) != st.witnesses() {
+		return false
+	}
+
+	return p.c.Equals(st.challenge(st.commitments(p.d, p.c)))
+}
+
+// simulateSigmaProofs draws the challenges, then the responses, of the
+// proofs of every statement but the known one
+func simulateSigmaProofs(rand io.Reader, statements []*sigmaStatement, known int) ([]*sigmaProof, error) {
+	proofs := make([]*sigmaProof, len(statements))
+
+	for i := range statements {
+		if i == known {
+			continue
+		}
+
+		c, err := randScalar(rand)
+		if err != nil {
+			return nil, err
+		}
+		proofs[i] = &sigmaProof{c: c}
+	}
+
+	for i, st := range statements {
+		if i == known {
+			continue
+		}
+
+		d, err := randScalars(rand, st.witnesses())
+		if err != nil {
+			return nil, err
+		}
+		proofs[i].d = d
+	}
+
+	return proofs, nil
+}
+
+// proveOr proves knowledge of the witnesses of one of the statements,
+// without revealing which one. The proofs of the other statements are
+// simulated, and the challenges of all of them add up to the challenge
+// derived from every commitment.
+func proveOr(rand io.Reader, statements []*sigmaStatement, known int, witnesses []ed448.Scalar, challenge fiatShamir) ([]*sigmaProof, error) {
+	nonces, err := randScalars(rand, len(witnesses))
+	if err != nil {
+		return nil, err
+	}
+
+	proofs, err := simulateSigmaProofs(rand, statements, known)
+	if err != nil {
+		return nil, err
+	}
+
+	var commitments []ed448.Point
+	for i, st := range statements {
+		if i == known {
+			commitments = append(commitments, st.commitments(nonces, nil)...)
+		} else {
+			commitments = append(commitments, st.commitments(proofs[i].d, proofs[i].c)...)
+		}
+	}
+
+	c := challenge(commitments)
+	for i, p := range proofs {
+		if i != known {
+			c.Sub(c, p.c)
+		}
+	}
+
+	proofs[known] = respond(nonces, witnesses, c)
+	return proofs, nil
+}
+
+func verifyOr(statements []*sigmaStatement, proofs []*sigmaProof, challenge fiatShamir) bool {
+	if len(proofs) != len(statements) {
+		return false
+	}
+
+	var commitments []ed448.Point
+	sum := ed448.NewScalar()
+	for i, st := range statements {
+		p := proofs[i]
+		if p == nil || p.c == nil || len(p.d) != st.witnesses() {
+			return false
+		}
+
+		commitments = append(commitments, st.commitments(p.d, p.c)...)
+		sum.Add(sum, p.c)
+	}
+
+	return challenge(commitments).Equals(sum)
+}
@@ -0,0 +1,93 @@
+package otr4
+
+import (
+	"crypto/rand"
+
+	"github.com/twstrike/ed448"
+
+	. "gopkg.in/check.v1"
+)
+
+func randTestScalar(c *C) ed448.Scalar {
+	s, err := randScalar(rand.Reader)
+	c.Assert(err, IsNil)
+	return s
+}
+
+func (s *OTR4Suite) Test_SigmaProofOfALinearStatement(c *C) {
+	x, y := randTestScalar(c), randTestScalar(c)
+	h := ed448.PrecomputedScalarMul(randTestScalar(c))
+
+	// a = x*G and b = x*h + y*G
+	a := ed448.PrecomputedScalarMul(x)
+	b := ed448.PointDoubleScalarMul(h, ed448.BasePoint, x, y)
+	st := &sigmaStatement{
+		relations: []sigmaRelation{
+			{image: a, bases: []ed448.Point{ed448.BasePoint}},
+			{image: b, bases: []ed448.Point{h, ed448.BasePoint}},
+		},
+		challenge: domainChallenge(0x42),
+	}
+
+	proof, err := st.prove(rand.Reader, x, y)
+	c.Assert(err, IsNil)
+	c.Assert(st.verify(proof), Equals, true)
+
+	other := *st
+	other.challenge = domainChallenge(0x43)
+	c.Assert(other.verify(proof), Equals, false)
+
+	proof, err = st.prove(rand.Reader, y, x)
+	c.Assert(err, IsNil)
+	c.Assert(st.verify(proof), Equals, false)
+
+	c.Assert(st.verify(newSigmaProof(proof.c, proof.d[0])), Equals, false)
+	c.Assert(st.verify(nil), Equals, false)
+}
+
+func (s *OTR4Suite) Test_SigmaProofDoesNotModifyTheWitnesses(c *C) {
+	x := randTestScalar(c)
+	before := x.Copy()
+
+	_, err := knowledgeStatement(ed448.PrecomputedScalarMul(x), domainChallenge(1)).prove(rand.Reader, x)
+
+	c.Assert(err, IsNil)
+	c.Assert(x.Equals(before), Equals, true)
+}
+
+func (s *OTR4Suite) Test_OrProofHidesWhichWitnessIsKnown(c *C) {
+	secrets := []ed448.Scalar{randTestScalar(c), randTestScalar(c), randTestScalar(c)}
+	pubs := make([]ed448.Point, len(secrets))
+	for i, x := range secrets {
+		pubs[i] = ed448.PrecomputedScalarMul(x)
+	}
+
+	challenge := domainChallenge(0x01)
+	for known := range secrets {
+		statements := ringStatements(pubs...)
+
+		proofs, err := proveOr(rand.Reader, statements, known, []ed448.Scalar{secrets[known]}, challenge)
+		c.Assert(err, IsNil)
+		c.Assert(verifyOr(statements, proofs, challenge), Equals, true)
+
+		c.Assert(verifyOr(statements, proofs, domainChallenge(0x02)), Equals, false)
+		c.Assert(verifyOr(statements[:2], proofs, challenge), Equals, false)
+
+		proofs[known].c.Add(proofs[known].c, proofs[known].c)
+		c.Assert(verifyOr(statements, proofs, challenge), Equals, false)
+	}
+}
+
+func (s *OTR4Suite) Test_OrProofNeedsTheWitness(c *C) {
+	pubs := []ed448.Point{
+		ed448.PrecomputedScalarMul(randTestScalar(c)),
+		ed448.PrecomputedScalarMul(randTestScalar(c)),
+	}
+	statements := ringStatements(pubs...)
+	challenge := domainChallenge(0x01)
+
+	proofs, err := proveOr(rand.Reader, statements, 0, []ed448.Scalar{randTestScalar(c)}, challenge)
+
+	c.Assert(err, IsNil)
+	c.Assert(verifyOr(statements, proofs, challenge), Equals, false)
+}
@@ -0,0 +1,94 @@
+package otr4
+
+// defaultMaxSkippedKeys is used when the conversation does not set one
+const defaultMaxSkippedKeys = 1000
+
+type skippedKeyID struct {
+	ratchetID uint32
+	messageID uint32
+}
+
+// skippedKeys stores the message keys of messages that have not arrived
+// yet, so they can still be decrypted when they are delivered out of order.
+// It holds at most max keys; when full, the oldest stored key is evicted.
+type skippedKeys struct {
+	max   int
+	keys  map[skippedKeyID][]byte
+	order []skippedKeyID
+}
+
+func newSkippedKeys(max int) *skippedKeys {
+	if max <= 0 {
+		max = defaultMaxSkippedKeys
+	}
+
+	return &skippedKeys{
+		max:  max,
+		keys: make(map[skippedKeyID][]byte),
+	}
+}
+
+func (s *skippedKeys) store(id skippedKeyID, mk []byte) {
+	if _, ok := s.keys[id]; ok {
+		return
+	}
+
+	for len(s.order) >= s.max {
+		s.evictOldest()
+	}
+
+	s.keys[id] = mk
+	s.order = append(s.order, id)
+}
+
+func (s *skippedKeys) evictOldest() {
+	oldest := s.order[0]
+	s.order = s.order[1:]
+	delete(s.keys, oldest)
+}
+
+// take returns the message key for the given id and forgets it, so a
+// message can only be decrypted once
+func (s *skippedKeys) take(id skippedKeyID) ([]byte, bool) {
+	mk, ok := s.keys[id]
+	if !ok {
+		return nil, false
+	}
+
+	delete(s.keys, id)
+	for i, o := range s.order {
+		if o == id {
+			s.order = append(s.order[:i:i], s.order[i+1:]...)
+			break
+		}
+	}
+
+	return mk, true
+}
+
+// drain returns all the stored keys, oldest first, and forgets them
+func (s *skippedKeys) drain() [][]byte {
+	out := make([][]byte, 0, len(s.order))
+	for _, id := range s.order {
+		out = append(out, s.keys[id])
+	}
+
+	s.keys = make(map[skippedKeyID][]byte)
+	s.order = nil
+
+	return out
+}
+
+func (s *skippedKeys) clone() *skippedKeys {
+	c := &skippedKeys{
+		max:   s.max,
+		keys:  make(map[skippedKeyID][]byte, len(s.keys)),
+		order: append([]skippedKeyID(nil), s.order...),
+	}
+
+	for id, mk := range s.keys {
+		c.keys[id] = mk
+	}
+
+	return c
+}
@@ -0,0 +1,49 @@
+package otr4
+
+import (
+	. "gopkg.in/check.v1"
+)
+
+func (s *OTR4Suite) Test_SkippedKeysStoreAndTake(c *C) {
+	keys := newSkippedKeys(0)
+
+	c.Assert(keys.max, Equals, defaultMaxSkippedKeys)
+
+	keys.store(skippedKeyID{1, 2}, []byte{0x01})
+
+	mk, ok := keys.take(skippedKeyID{1, 2})
+	c.Assert(ok, Equals, true)
+	c.Assert(mk, DeepEquals, []byte{0x01})
+
+	_, ok = keys.take(skippedKeyID{1, 2})
+	c.Assert(ok, Equals, false)
+	c.Assert(keys.order, HasLen, 0)
+}
+
+func (s *OTR4Suite) Test_SkippedKeysEvictsTheOldest(c *C) {
+	keys := newSkippedKeys(2)
+
+	keys.store(skippedKeyID{0, 0}, []byte{0x01})
+	keys.store(skippedKeyID{0, 1}, []byte{0x02})
+	keys.store(skippedKeyID{1, 0}, []byte{0x03})
+
+	c.Assert(keys.keys, HasLen, 2)
+
+	_, ok := keys.take(skippedKeyID{0, 0})
+	c.Assert(ok, Equals, false)
+
+	mk, ok := keys.take(skippedKeyID{1, 0})
+	c.Assert(ok, Equals, true)
+	c.Assert(mk, DeepEquals, []byte{0x03})
+}
+
+func (s *OTR4Suite) Test_SkippedKeysClone(c *C) {
+	keys := newSkippedKeys(2)
+	keys.store(skippedKeyID{0, 0}, []byte{0x01})
+
+	clone := keys.clone()
+	clone.take(skippedKeyID{0, 0})
+
+	_, ok := keys.take(skippedKeyID{0, 0})
+	c.Assert(ok, Equals, true)
+}
@@ -1,8 +1,12 @@
 package otr4
 
 import (
+	"io"
+	"strings"
+
 	"github.com/twstrike/ed448"
 	"golang.org/x/crypto/sha3"
+	"golang.org/x/text/unicode/norm"
 )
 
 const smpVersion = 1
@@ -23,46 +27,528 @@ func generateDZKP(r, a, c ed448.Scalar) ed448.Scalar {
 	return r
 }
 
-func generateZKP(r, a ed448.Scalar, ix byte) (ed448.Scalar, ed448.Scalar) {
-	gr := ed448.PrecomputedScalarMul(r)
-	c := hashToScalar(ix, gr)
-	d := generateDZKP(r, a, c)
+// smpKnowledge states knowledge of the discrete log of g
+func smpKnowledge(g ed448.Point, ix byte) *sigmaStatement {
+	return knowledgeStatement(g, domainChallenge(ix))
+}
+
+// smpCoordinates states p = r*g3 and q = r*G + secret*g2, for the same r
+func smpCoordinates(g2, g3, p, q ed448.Point, ix byte) *sigmaStatement {
+	return &sigmaStatement{
+		relations: []sigmaRelation{
+			{image: p, bases: []ed448.Point{g3, nil}},
+			{image: q, bases: []ed448.Point{ed448.BasePoint, g2}},
+		},
+		challenge: domainChallenge(ix),
+	}
+}
+
+// smpRatio states g3x = x*G and r = x*(qa - qb), for the same x
+func smpRatio(g3x, qa, qb, r ed448.Point, ix byte) *sigmaStatement {
+	qab := ed448.NewPointFromBytes()
+	qab.Sub(qa, qb)
+
+	return &sigmaStatement{
+		relations: []sigmaRelation{
+			{image: g3x, bases: []ed448.Point{ed448.BasePoint}},
+			{image: r, bases: []ed448.Point{qab}},
+		},
+		challenge: domainChallenge(ix),
+	}
+}
+
+type smpState int
+
+const (
+	smpStateExpect1 smpState = iota
+	smpStateExpect2
+	smpStateExpect3
+	smpStateExpect4
+)
+
+// smp runs the Socialist Millionaires' Protocol, which tells both parties
+// whether they share the same secret without revealing anything else
+// about it. Any error resets it, and the peer should be sent an abort.
+type smp struct {
+	state smpState
+
+	// received is the SMP1 message waiting for the user to provide the
+	// secret
+	received *smp1Message
+
+	secret ed448.Scalar
+	// our2 and our3 are our exponents of g2 and g3: a2 and a3 for the
+	// initiator, b2 and b3 for the responder
+	our2, our3 ed448.Scalar
+	g2, g3     ed448.Point
+	theirG3    ed448.Point
+	pa, qa     ed448.Point
+	pb, qb     ed448.Point
+}
+
+func (s *smp) abort() {
+	*s = smp{}
+}
+
+// proveKnowledge proves knowledge of a, the discrete log of g
+func proveKnowledge(rand io.Reader, g ed448.Point, a ed448.Scalar, ix byte) (ed448.Scalar, ed448.Scalar, error) {
+	p, err := smpKnowledge(g, ix).prove(rand, a)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return p.c, p.d[0], nil
+}
+
+// proveCoordinates proves p = r*g3 and q = r*G + secret*g2
+func (s *smp) proveCoordinates(rand io.Reader, p, q ed448.Point, r ed448.Scalar, ix byte) (ed448.Scalar, ed448.Scalar, ed448.Scalar, error) {
+	proof, err := smpCoordinates(s.g2, s.g3, p, q, ix).prove(rand, r, s.secret)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	return proof.c, proof.d[0], proof.d[1], nil
+}
+
+// proveRatio computes R = our3*(Qa - Qb) and proves our3 is the scalar
+// behind our g3 value
+func (s *smp) proveRatio(rand io.Reader, ix byte) (ed448.Point, ed448.Scalar, ed448.Scalar, error) {
+	qab := ed448.NewPointFromBytes()
+	qab.Sub(s.qa, s.qb)
+	r := ed448.PointScalarMul(qab, s.our3)
+
+	ourG3 := ed448.PrecomputedScalarMul(s.our3)
+	proof, err := smpRatio(ourG3, s.qa, s.qb, r, ix).prove(rand, s.our3)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	return r, proof.c, proof.d[0], nil
+}
+
+// compare checks whether both secrets were the same, given the R value of
+// the peer
+func (s *smp) compare(theirR ed448.Point) bool {
+	rab := ed448.PointScalarMul(theirR, s.our3)
+
+	pab := ed448.NewPointFromBytes()
+	pab.Sub(s.pa, s.pb)
+
+	return rab.Equals(pab)
+}
+
+// validSMPPoints checks none of the points is the identity, or another
+// point of low order. The values compared at the end would then not
+// depend on the secrets, and anyone could make SMP succeed.
+func validSMPPoints(ps ...ed448.Point) bool {
+	for _, p := range ps {
+		if isLowOrderPoint(p) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// validDifferences checks Pa - Pb and Qa - Qb, once all four are known
+func (s *smp) validDifferences() bool {
+	pab := ed448.NewPointFromBytes()
+	pab.Sub(s.pa, s.pb)
 
-	return c, d
+	qab := ed448.NewPointFromBytes()
+	qab.Sub(s.qa, s.qb)
+
+	return validSMPPoints(pab, qab)
 }
 
-func verifyZKP(d, c ed448.Scalar, g ed448.Point, ix byte) bool {
-	r := ed448.PrecomputedScalarMul(d)
-	s := ed448.PointScalarMul(g, c)
-	p := ed448.NewPointFromBytes()
-	p.Add(r, s)
-	t := hashToScalar(ix, p)
-	return c.Equals(t)
+// start begins the protocol as the initiator, with an optional question
+// for the peer
+func (s *smp) start(rand io.Reader, secret ed448.Scalar, question string) (*smp1Message, error) {
+	s.abort()
+
+	var err error
+	if s.our2, err = randScalar(rand); err != nil {
+		return nil, err
+	}
+
+	if s.our3, err = randScalar(rand); err != nil {
+		return nil, err
+	}
+
+	m := &smp1Message{
+		question: question,
+		g2a:      ed448.PrecomputedScalarMul(s.our2),
+		g3a:      ed448.PrecomputedScalarMul(s.our3),
+	}
+
+	if m.c2, m.d2, err = proveKnowledge(rand, m.g2a, s.our2, 1); err != nil {
+		return nil, err
+	}
+
+	if m.c3, m.d3, err = proveKnowledge(rand, m.g3a, s.our3, 2); err != nil {
+		return nil, err
+	}
+
+	s.secret = secret
+	s.state = smpStateExpect2
+
+	return m, nil
+}
+
+// receiveMessage1 checks the SMP1 message of the initiator. The protocol
+// goes on once the user provides their secret through respond.
+func (s *smp) receiveMessage1(m *smp1Message) error {
+	if s.state != smpStateExpect1 {
+		s.abort()
+		return errUnexpectedSMPMessage
+	}
+
+	if !smpKnowledge(m.g2a, 1).verify(newSigmaProof(m.c2, m.d2)) ||
+		!smpKnowledge(m.g3a, 2).verify(newSigmaProof(m.c3, m.d3)) {
+		s.abort()
+		return errSMPCheated
+	}
+
+	s.received = m
+	return nil
 }
 
-func verifyZKP2(g2, g3, pb, qb ed448.Point, d5, d6, cp ed448.Scalar, ix byte) bool {
-	l := ed448.PointDoubleScalarMul(g3, pb, d5, cp)
-	r := ed448.PointDoubleScalarMul(ed448.BasePoint, g2, d5, d6)
-	s := ed448.PointScalarMul(qb, cp)
-	r.Add(r, s)
-	t := hashToScalar(ix, l, r)
-	return cp.Equals(t)
+// respond continues the protocol with the secret of the responder
+func (s *smp) respond(rand io.Reader, secret ed448.Scalar) (*smp2Message, error) {
+	if s.state != smpStateExpect1 || s.received == nil {
+		return nil, errUnexpectedSMPMessage
+	}
+
+	m1 := s.received
+	s.received = nil
+	s.secret = secret
+
+	m, err := s.generateMessage2(rand, m1)
+	if err != nil {
+		s.abort()
+		return nil, err
+	}
+
+	s.state = smpStateExpect3
+	return m, nil
 }
 
-func verifyZKP3(g2, g3, pa, qa ed448.Point, d5, d6, cp ed448.Scalar, ix byte) bool {
-	l := ed448.PointDoubleScalarMul(g3, pa, d5, cp)
-	r := ed448.PointDoubleScalarMul(ed448.BasePoint, g2, d5, d6)
-	s := ed448.PointScalarMul(qa, cp)
-	r.Add(r, s)
-	t := hashToScalar(ix, l, r)
-	return cp.Equals(t)
+func (s *smp) generateMessage2(rand io.Reader, m1 *smp1Message) (*smp2Message, error) {
+	var err error
+	if s.our2, err = randScalar(rand); err != nil {
+		return nil, err
+	}
+
+	if s.our3, err = randScalar(rand); err != nil {
+		return nil, err
+	}
+
+	r4, err := randScalar(rand)
+	if err != nil {
+		return nil, err
+	}
+
+	m := &smp2Message{
+		g2b: ed448.PrecomputedScalarMul(s.our2),
+		g3b: ed448.PrecomputedScalarMul(s.our3),
+	}
+
+	if m.c2, m.d2, err = proveKnowledge(rand, m.g2b, s.our2, 3); err != nil {
+		return nil, err
+	}
+
+	if m.c3, m.d3, err = proveKnowledge(rand, m.g3b, s.our3, 4); err != nil {
+		return nil, err
+	}
+
+	s.g2 = ed448.PointScalarMul(m1.g2a, s.our2)
+	s.g3 = ed448.PointScalarMul(m1.g3a, s.our3)
+	s.theirG3 = m1.g3a
+
+	if !validSMPPoints(s.g2, s.g3) {
+		return nil, errSMPCheated
+	}
+
+	s.pb = ed448.PointScalarMul(s.g3, r4)
+	s.qb = ed448.PointDoubleScalarMul(ed448.BasePoint, s.g2, r4, s.secret)
+	m.pb, m.qb = s.pb, s.qb
+
+	if m.cp, m.d5, m.d6, err = s.proveCoordinates(rand, s.pb, s.qb, r4, 5); err != nil {
+		return nil, err
+	}
+
+	return m, nil
 }
 
-func verifyZKP4(g3a, qa, qb, ra ed448.Point, d7, cr ed448.Scalar, ix byte) bool {
-	s := ed448.NewPointFromBytes()
-	s.Sub(qa, qb)
-	l := ed448.PointDoubleScalarMul(ed448.BasePoint, g3a, d7, cr)
-	r := ed448.PointDoubleScalarMul(s, ra, d7, cr)
-	t := hashToScalar(ix, l, r)
-	return cr.Equals(t)
+// receiveMessage2 checks the SMP2 message of the responder and returns the
+// SMP3 message to send back
+func (s *smp) receiveMessage2(rand io.Reader, m *smp2Message) (*smp3Message, error) {
+	if s.state != smpStateExpect2 {
+		s.abort()
+		return nil, errUnexpectedSMPMessage
+	}
+
+	if !smpKnowledge(m.g2b, 3).verify(newSigmaProof(m.c2, m.d2)) ||
+		!smpKnowledge(m.g3b, 4).verify(newSigmaProof(m.c3, m.d3)) {
+		s.abort()
+		return nil, errSMPCheated
+	}
+
+	s.g2 = ed448.PointScalarMul(m.g2b, s.our2)
+	s.g3 = ed448.PointScalarMul(m.g3b, s.our3)
+	s.theirG3 = m.g3b
+
+	if !validSMPPoints(s.g2, s.g3) {
+		s.abort()
+		return nil, errSMPCheated
+	}
+
+	if !smpCoordinates(s.g2, s.g3, m.pb, m.qb, 5).verify(newSigmaProof(m.cp, m.d5, m.d6)) {
+		s.abort()
+		return nil, errSMPCheated
+	}
+	s.pb, s.qb = m.pb, m.qb
+
+	m3, err := s.generateMessage3(rand)
+	if err != nil {
+		s.abort()
+		return nil, err
+	}
+
+	s.state = smpStateExpect4
+	return m3, nil
+}
+
+func (s *smp) generateMessage3(rand io.Reader) (*smp3Message, error) {
+	r4, err := randScalar(rand)
+	if err != nil {
+		return nil, err
+	}
+
+	s.pa = ed448.PointScalarMul(s.g3, r4)
+	s.qa = ed448.PointDoubleScalarMul(ed448.BasePoint, s.g2, r4, s.secret)
+
+	if !s.validDifferences() {
+		return nil, errSMPCheated
+	}
+
+	m := &smp3Message{pa: s.pa, qa: s.qa}
+
+	if m.cp, m.d5, m.d6, err = s.proveCoordinates(rand, s.pa, s.qa, r4, 6); err != nil {
+		return nil, err
+	}
+
+	if m.ra, m.cr, m.d7, err = s.proveRatio(rand, 7); err != nil {
+		return nil, err
+	}
+
+	return m, nil
+}
+
+// receiveMessage3 checks the SMP3 message of the initiator. It returns the
+// SMP4 message to send back and whether both secrets were the same.
+func (s *smp) receiveMessage3(rand io.Reader, m *smp3Message) (*smp4Message, bool, error) {
+	if s.state != smpStateExpect3 {
+		s.abort()
+		return nil, false, errUnexpectedSMPMessage
+	}
+
+	if !smpCoordinates(s.g2, s.g3, m.pa, m.qa, 6).verify(newSigmaProof(m.cp, m.d5, m.d6)) {
+		s.abort()
+		return nil, false, errSMPCheated
+	}
+	s.pa, s.qa = m.pa, m.qa
+
+	if !s.validDifferences() {
+		s.abort()
+		return nil, false, errSMPCheated
+	}
+
+	if !smpRatio(s.theirG3, s.qa, s.qb, m.ra, 7).verify(newSigmaProof(m.cr, m.d7)) {
+		s.abort()
+		return nil, false, errSMPCheated
+	}
+
+	m4 := &smp4Message{}
+	var err error
+	if m4.rb, m4.cr, m4.d7, err = s.proveRatio(rand, 8); err != nil {
+		s.abort()
+		return nil, false, err
+	}
+
+	success := s.compare(m.ra)
+	s.abort()
+
+	return m4, success, nil
+}
+
+// receiveMessage4 checks the SMP4 message of the responder and returns
+// whether both secrets were the same
+func (s *smp) receiveMessage4(m *smp4Message) (bool, error) {
+	if s.state != smpStateExpect4 {
+		s.abort()
+		return false, errUnexpectedSMPMessage
+	}
+
+	if !smpRatio(s.theirG3, s.qa, s.qb, m.rb, 8).verify(newSigmaProof(m.cr, m.d7)) {
+		s.abort()
+		return false, errSMPCheated
+	}
+
+	success := s.compare(m.rb)
+	s.abort()
+
+	return success, nil
+}
+
+// normalizeSMPAnswer makes answers that only differ in their Unicode
+// normalization or surrounding whitespace the same secret
+func normalizeSMPAnswer(answer string) []byte {
+	return []byte(strings.TrimSpace(norm.NFC.String(answer)))
+}
+
+// smpSecret derives the secret compared by SMP from the user's answer. It
+// is bound to the session and to the fingerprints of the identities in the
+// profiles exchanged in the DAKE, so a successful SMP also authenticates
+// the DAKE.
+func (c *Conversation) smpSecret(initiator bool, answer string) (ed448.Scalar, error) {
+	if c.msgState != encrypted || c.profile == nil || c.theirProfile == nil || c.ssid == nil {
+		return nil, errUnexpectedState
+	}
+
+	ours := c.profile.pub.fingerprint()
+	theirs := c.theirProfile.pub.fingerprint()
+
+	first, second := ours, theirs
+	if !initiator {
+		first, second = theirs, ours
+	}
+
+	secret := generateSMPsecret(first[:], second[:], c.ssid, normalizeSMPAnswer(answer))
+	return ed448.NewScalar(secret[:fieldBytes]), nil
+}
+
+// StartSMP starts verifying the peer with an answer both users should
+// know. The question is shown to the peer, and can be empty.
+func (c *Conversation) StartSMP(question, answer string) ([][]byte, error) {
+	secret, err := c.smpSecret(true, answer)
+	if err != nil {
+		return nil, err
+	}
+
+	m, err := c.smp.start(c.rand(), secret, question)
+	if err != nil {
+		return nil, err
+	}
+
+	toSend, err := c.sendTLVs(m.tlv())
+	if err != nil {
+		return nil, err
+	}
+
+	c.smpEvent(SMPEventWaitingForPeer, "")
+	return toSend, nil
+}
+
+// RespondSMP answers the SMP started by the peer
+func (c *Conversation) RespondSMP(answer string) ([][]byte, error) {
+	secret, err := c.smpSecret(false, answer)
+	if err != nil {
+		return nil, err
+	}
+
+	m, err := c.smp.respond(c.rand(), secret)
+	if err != nil {
+		return nil, err
+	}
+
+	toSend, err := c.sendTLVs(m.tlv())
+	if err != nil {
+		return nil, err
+	}
+
+	c.smpEvent(SMPEventInProgress, "")
+	return toSend, nil
+}
+
+// AbortSMP stops the SMP in progress and lets the peer know
+func (c *Conversation) AbortSMP() ([][]byte, error) {
+	c.smp.abort()
+	return c.sendTLVs(TLV{Type: tlvTypeSMPAbort})
+}
+
+func (c *Conversation) receiveSMP(t TLV) error {
+	if t.Type == tlvTypeSMPAbort {
+		c.smp.abort()
+		c.smpEvent(SMPEventAborted, "")
+		return nil
+	}
+
+	m, err := parseSMPMessage(t)
+	if err != nil {
+		c.abortSMPAfter(err)
+		return nil
+	}
+
+	reply, event, err := c.advanceSMP(m)
+	if err != nil {
+		c.abortSMPAfter(err)
+		return nil
+	}
+
+	if reply != nil {
+		c.replyTLVs = append(c.replyTLVs, reply.tlv())
+	}
+
+	question := ""
+	if m1, ok := m.(*smp1Message); ok {
+		question = m1.question
+	}
+
+	// a failure to save the result must not keep the reply from being
+	// sent, or the peer would wait for it forever
+	if event == SMPEventSucceeded {
+		event = c.smpSucceeded()
+	}
+
+	c.smpEvent(event, question)
+	return nil
+}
+
+// abortSMPAfter resets SMP after an invalid message and lets the peer know
+func (c *Conversation) abortSMPAfter(err error) {
+	c.smp.abort()
+	c.replyTLVs = append(c.replyTLVs, TLV{Type: tlvTypeSMPAbort})
+
+	if err == errUnexpectedSMPMessage {
+		c.smpEvent(SMPEventAborted, "")
+	} else {
+		c.smpEvent(SMPEventCheated, "")
+	}
+}
+
+// advanceSMP feeds a received message to the state machine. It returns the
+// message to send back, if any, and the event to report.
+func (c *Conversation) advanceSMP(m smpMessage) (smpMessage, SMPEvent, error) {
+	switch m := m.(type) {
+	case *smp1Message:
+		return nil, SMPEventAskForAnswer, c.smp.receiveMessage1(m)
+	case *smp2Message:
+		m3, err := c.smp.receiveMessage2(c.rand(), m)
+		if err != nil {
+			return nil, 0, err
+		}
+		return m3, SMPEventInProgress, nil
+	case *smp3Message:
+		m4, success, err := c.smp.receiveMessage3(c.rand(), m)
+		if err != nil {
+			return nil, 0, err
+		}
+		return m4, smpResultEvent(success), nil
+	case *smp4Message:
+		success, err := c.smp.receiveMessage4(m)
+		return nil, smpResultEvent(success), err
+	}
+
+	return nil, 0, errUnexpectedSMPMessage
 }
@@ -0,0 +1,90 @@
+package otr4
+
+// SMPEvent tells the host application how an SMP run is going
+type SMPEvent int
+
+const (
+	// SMPEventAskForAnswer means the peer started SMP. The user should
+	// answer their question, if any, through RespondSMP.
+	SMPEventAskForAnswer SMPEvent = iota
+	// SMPEventWaitingForPeer means we started SMP and the peer has to
+	// answer
+	SMPEventWaitingForPeer
+	// SMPEventInProgress means both answers were given and they are being
+	// compared
+	SMPEventInProgress
+	// SMPEventSucceeded means both users gave the same answer. The
+	// fingerprint of the peer has been marked as verified.
+	SMPEventSucceeded
+	// SMPEventFailed means the answers were different
+	SMPEventFailed
+	// SMPEventCheated means the peer sent an invalid SMP message
+	SMPEventCheated
+	// SMPEventAborted means the peer aborted SMP, or sent a message out of
+	// order
+	SMPEventAborted
+	// SMPEventVerificationNotSaved means both users gave the same answer,
+	// but the trust store failed to mark the fingerprint of the peer as
+	// verified
+	SMPEventVerificationNotSaved
+)
+
+var smpEventNames = map[SMPEvent]string{
+	SMPEventAskForAnswer:   "ask for answer",
+	SMPEventWaitingForPeer: "waiting for peer",
+	SMPEventInProgress:     "in progress",
+	SMPEventSucceeded:      "succeeded",
+	SMPEventFailed:         "failed",
+	SMPEventCheated:        "cheated",
+	SMPEventAborted:        "aborted",
+
+	SMPEventVerificationNotSaved: "verification not saved",
+}
+
+func (e SMPEvent) String() string {
+	if name, ok := smpEventNames[e]; ok {
+		return name
+	}
+
+	return "unknown SMP event"
+}
+
+// SMPEventHandler is implemented by the host application to follow SMP.
+// The question is only set with SMPEventAskForAnswer.
+type SMPEventHandler interface {
+	HandleSMPEvent(event SMPEvent, question string)
+}
+
+// TrustStore keeps the fingerprints the user has verified
+type TrustStore interface {
+	MarkVerified(fp Fingerprint) error
+}
+
+func smpResultEvent(success bool) SMPEvent {
+	if success {
+		return SMPEventSucceeded
+	}
+
+	return SMPEventFailed
+}
+
+func (c *Conversation) smpEvent(event SMPEvent, question string) {
+	if c.SMPEvents != nil {
+		c.SMPEvents.HandleSMPEvent(event, question)
+	}
+}
+
+// smpSucceeded marks the fingerprint of the peer as verified, and returns
+// the event to report
+func (c *Conversation) smpSucceeded() SMPEvent {
+	fp, ok := c.TheirFingerprint()
+	if c.Trust == nil || !ok {
+		return SMPEventSucceeded
+	}
+
+	if err := c.Trust.MarkVerified(fp); err != nil {
+		return SMPEventVerificationNotSaved
+	}
+
+	return SMPEventSucceeded
+}
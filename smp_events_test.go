@@ -0,0 +1,180 @@
+package otr4
+
+import (
+	"crypto/rand"
+
+	. "gopkg.in/check.v1"
+)
+
+type recordedSMPEvent struct {
+	event    SMPEvent
+	question string
+}
+
+type smpEventRecorder struct {
+	events []recordedSMPEvent
+}
+
+func (r *smpEventRecorder) HandleSMPEvent(event SMPEvent, question string) {
+	r.events = append(r.events, recordedSMPEvent{event, question})
+}
+
+type memoryTrustStore struct {
+	verified []Fingerprint
+	err      error
+}
+
+func (t *memoryTrustStore) MarkVerified(fp Fingerprint) error {
+	if t.err != nil {
+		return t.err
+	}
+
+	t.verified = append(t.verified, fp)
+	return nil
+}
+
+func establishSMPSession(c *C) (alice, bob *Conversation, aliceEvents, bobEvents *smpEventRecorder, aliceTrust, bobTrust *memoryTrustStore) {
+	alice, bob = establishSession(c)
+
+	aliceEvents, bobEvents = &smpEventRecorder{}, &smpEventRecorder{}
+	aliceTrust, bobTrust = &memoryTrustStore{}, &memoryTrustStore{}
+
+	alice.SMPEvents, alice.Trust = aliceEvents, aliceTrust
+	bob.SMPEvents, bob.Trust = bobEvents, bobTrust
+
+	return
+}
+
+func runConversationSMP(c *C, alice, bob *Conversation, aliceAnswer, bobAnswer string) {
+	toSend, err := alice.StartSMP("favourite food?", aliceAnswer)
+	c.Assert(err, IsNil)
+	c.Assert(receiveOne(c, bob, toSend), IsNil)
+
+	toSend, err = bob.RespondSMP(bobAnswer)
+	c.Assert(err, IsNil)
+
+	toSend = receiveOne(c, alice, toSend)
+	toSend = receiveOne(c, bob, toSend)
+	c.Assert(receiveOne(c, alice, toSend), IsNil)
+}
+
+func (s *OTR4Suite) Test_SMPEventsWhenSMPSucceeds(c *C) {
+	alice, bob, aliceEvents, bobEvents, aliceTrust, bobTrust := establishSMPSession(c)
+
+	runConversationSMP(c, alice, bob, "pizza", "pizza")
+
+	c.Assert(aliceEvents.events, DeepEquals, []recordedSMPEvent{
+		{SMPEventWaitingForPeer, ""},
+		{SMPEventInProgress, ""},
+		{SMPEventSucceeded, ""},
+	})
+	c.Assert(bobEvents.events, DeepEquals, []recordedSMPEvent{
+		{SMPEventAskForAnswer, "favourite food?"},
+		{SMPEventInProgress, ""},
+		{SMPEventSucceeded, ""},
+	})
+
+	c.Assert(aliceTrust.verified, DeepEquals, []Fingerprint{bob.keys.pub.fingerprint()})
+	c.Assert(bobTrust.verified, DeepEquals, []Fingerprint{alice.keys.pub.fingerprint()})
+}
+
+func (s *OTR4Suite) Test_SMPEventsWhenSMPFails(c *C) {
+	alice, bob, aliceEvents, bobEvents, aliceTrust, bobTrust := establishSMPSession(c)
+
+	runConversationSMP(c, alice, bob, "pizza", "pasta")
+
+	c.Assert(aliceEvents.events[len(aliceEvents.events)-1].event, Equals, SMPEventFailed)
+	c.Assert(bobEvents.events[len(bobEvents.events)-1].event, Equals, SMPEventFailed)
+
+	c.Assert(aliceTrust.verified, IsNil)
+	c.Assert(bobTrust.verified, IsNil)
+}
+
+func (s *OTR4Suite) Test_SMPEventsWhenThePeerCheats(c *C) {
+	alice, bob, _, bobEvents, _, _ := establishSMPSession(c)
+
+	m1, err := (&smp{}).start(rand.Reader, shakeToScalar([]byte("secret")), "")
+	c.Assert(err, IsNil)
+	m1.d3.Add(m1.d3, m1.c3)
+
+	toSend, err := alice.sendTLVs(m1.tlv())
+	c.Assert(err, IsNil)
+	c.Assert(receiveOne(c, bob, toSend), HasLen, 1)
+
+	c.Assert(bobEvents.events, DeepEquals, []recordedSMPEvent{{SMPEventCheated, ""}})
+}
+
+func (s *OTR4Suite) Test_SMPEventsWhenThePeerAborts(c *C) {
+	alice, bob, aliceEvents, _, _, _ := establishSMPSession(c)
+
+	toSend, err := alice.StartSMP("", "pizza")
+	c.Assert(err, IsNil)
+	c.Assert(receiveOne(c, bob, toSend), IsNil)
+
+	toSend, err = bob.AbortSMP()
+	c.Assert(err, IsNil)
+	c.Assert(receiveOne(c, alice, toSend), IsNil)
+
+	c.Assert(aliceEvents.events, DeepEquals, []recordedSMPEvent{
+		{SMPEventWaitingForPeer, ""},
+		{SMPEventAborted, ""},
+	})
+}
+
+func (s *OTR4Suite) Test_TrustStoreFailuresAreReported(c *C) {
+	alice, bob, aliceEvents, bobEvents, aliceTrust, bobTrust := establishSMPSession(c)
+	aliceTrust.err = errInvalidFingerprint
+	bobTrust.err = errInvalidFingerprint
+
+	toSend, err := alice.StartSMP("", "pizza")
+	c.Assert(err, IsNil)
+	c.Assert(receiveOne(c, bob, toSend), IsNil)
+
+	toSend, err = bob.RespondSMP("pizza")
+	c.Assert(err, IsNil)
+	smp3 := receiveOne(c, alice, toSend)
+
+	// bob, the responder, still sends SMP4
+	plain, smp4, err := bob.Receive(smp3[0])
+	c.Assert(err, IsNil)
+	c.Assert(plain, IsNil)
+	c.Assert(smp4, HasLen, 1)
+	c.Assert(bob.replyTLVs, IsNil)
+	c.Assert(bobEvents.events[len(bobEvents.events)-1].event, Equals, SMPEventVerificationNotSaved)
+
+	c.Assert(receiveOne(c, alice, smp4), IsNil)
+	c.Assert(aliceEvents.events[len(aliceEvents.events)-1].event, Equals, SMPEventVerificationNotSaved)
+
+	toSend, err = bob.Send([]byte("hi alice"))
+	c.Assert(err, IsNil)
+	c.Assert(receivedTLVs(c, alice, toSend[0]), IsNil)
+}
+
+func (s *OTR4Suite) Test_SMPEventString(c *C) {
+	c.Assert(SMPEventSucceeded.String(), Equals, "succeeded")
+	c.Assert(SMPEvent(42).String(), Equals, "unknown SMP event")
+}
+
+func (s *OTR4Suite) Test_SMPCannotBeForcedToSucceed(c *C) {
+	alice, bob, aliceEvents, _, aliceTrust, _ := establishSMPSession(c)
+
+	toSend, err := alice.StartSMP("", "pizza")
+	c.Assert(err, IsNil)
+
+	// bob does not know the answer, and sends low order values instead
+	c.Assert(receiveOne(c, bob, toSend), IsNil)
+	m2 := forcedSuccessSMP2(c, bob.smp.received)
+
+	toSend, err = bob.sendTLVs(m2.tlv())
+	c.Assert(err, IsNil)
+	toSend = receiveOne(c, alice, toSend)
+	c.Assert(toSend, HasLen, 1)
+	c.Assert(receivedTLVs(c, bob, toSend[0]), DeepEquals, []TLV{{Type: tlvTypeSMPAbort, Value: []byte{}}})
+
+	c.Assert(aliceEvents.events, DeepEquals, []recordedSMPEvent{
+		{SMPEventWaitingForPeer, ""},
+		{SMPEventCheated, ""},
+	})
+	c.Assert(alice.smp.state, Equals, smpStateExpect1)
+	c.Assert(aliceTrust.verified, IsNil)
+}
@@ -0,0 +1,231 @@
+package otr4
+
+import (
+	"unicode/utf8"
+
+	"github.com/twstrike/ed448"
+)
+
+// smpMessage is one of the four SMP messages, sent as a TLV in a data
+// message
+type smpMessage interface {
+	tlv() TLV
+}
+
+type smp1Message struct {
+	question string
+	g2a, g3a ed448.Point
+	c2, d2   ed448.Scalar
+	c3, d3   ed448.Scalar
+}
+
+type smp2Message struct {
+	g2b, g3b ed448.Point
+	c2, d2   ed448.Scalar
+	c3, d3   ed448.Scalar
+	pb, qb   ed448.Point
+	cp       ed448.Scalar
+	d5, d6   ed448.Scalar
+}
+
+type smp3Message struct {
+	pa, qa ed448.Point
+	cp     ed448.Scalar
+	d5, d6 ed448.Scalar
+	ra     ed448.Point
+	cr, d7 ed448.Scalar
+}
+
+type smp4Message struct {
+	rb     ed448.Point
+	cr, d7 ed448.Scalar
+}
+
+func appendSMPPoints(b []byte, ps ...ed448.Point) []byte {
+	for _, p := range ps {
+		b = append(b, p.Encode()...)
+	}
+	return b
+}
+
+func appendScalars(b []byte, ss ...ed448.Scalar) []byte {
+	for _, s := range ss {
+		b = appendScalar(b, s)
+	}
+	return b
+}
+
+// extractSMPPoints reads points, checking every one of them is on the curve
+// and is not the identity or another point of low order
+func extractSMPPoints(bs []byte, ps ...*ed448.Point) ([]byte, error) {
+	var err error
+	for _, p := range ps {
+		*p, bs, err = extractECDHPoint(bs)
+		if err != nil {
+			return nil, err
+		}
+
+		if isLowOrderPoint(*p) {
+			return nil, errInvalidPoint
+		}
+	}
+	return bs, nil
+}
+
+// extractScalars reads scalars, checking every one of them is canonical
+func extractScalars(bs []byte, ss ...*ed448.Scalar) ([]byte, error) {
+	var err error
+	for _, s := range ss {
+		bs, *s, err = extractScalar(bs)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return bs, nil
+}
+
+func (m *smp1Message) tlv() TLV {
+	out := appendData(nil, []byte(m.question))
+	out = appendSMPPoints(out, m.g2a)
+	out = appendScalars(out, m.c2, m.d2)
+	out = appendSMPPoints(out, m.g3a)
+	out = appendScalars(out, m.c3, m.d3)
+
+	return TLV{Type: tlvTypeSMP1, Value: out}
+}
+
+func (m *smp1Message) deserialize(bs []byte) error {
+	bs, question, ok := extractData(bs)
+	if !ok {
+		return errInvalidLength
+	}
+
+	if !utf8.Valid(question) {
+		return errInvalidSMPQuestion
+	}
+	m.question = string(question)
+
+	bs, err := extractSMPPoints(bs, &m.g2a)
+	if err == nil {
+		bs, err = extractScalars(bs, &m.c2, &m.d2)
+	}
+	if err == nil {
+		bs, err = extractSMPPoints(bs, &m.g3a)
+	}
+	if err == nil {
+		bs, err = extractScalars(bs, &m.c3, &m.d3)
+	}
+
+	return finishSMPMessage(bs, err)
+}
+
+func (m *smp2Message) tlv() TLV {
+	out := appendSMPPoints(nil, m.g2b)
+	out = appendScalars(out, m.c2, m.d2)
+	out = appendSMPPoints(out, m.g3b)
+	out = appendScalars(out, m.c3, m.d3)
+	out = appendSMPPoints(out, m.pb, m.qb)
+	out = appendScalars(out, m.cp, m.d5, m.d6)
+
+	return TLV{Type: tlvTypeSMP2, Value: out}
+}
+
+func (m *smp2Message) deserialize(bs []byte) error {
+	bs, err := extractSMPPoints(bs, &m.g2b)
+	if err == nil {
+		bs, err = extractScalars(bs, &m.c2, &m.d2)
+	}
+	if err == nil {
+		bs, err = extractSMPPoints(bs, &m.g3b)
+	}
+	if err == nil {
+		bs, err = extractScalars(bs, &m.c3, &m.d3)
+	}
+	if err == nil {
+		bs, err = extractSMPPoints(bs, &m.pb, &m.qb)
+	}
+	if err == nil {
+		bs, err = extractScalars(bs, &m.cp, &m.d5, &m.d6)
+	}
+
+	return finishSMPMessage(bs, err)
+}
+
+func (m *smp3Message) tlv() TLV {
+	out := appendSMPPoints(nil, m.pa, m.qa)
+	out = appendScalars(out, m.cp, m.d5, m.d6)
+	out = appendSMPPoints(out, m.ra)
+	out = appendScalars(out, m.cr, m.d7)
+
+	return TLV{Type: tlvTypeSMP3, Value: out}
+}
+
+func (m *smp3Message) deserialize(bs []byte) error {
+	bs, err := extractSMPPoints(bs, &m.pa, &m.qa)
+	if err == nil {
+		bs, err = extractScalars(bs, &m.cp, &m.d5, &m.d6)
+	}
+	if err == nil {
+		bs, err = extractSMPPoints(bs, &m.ra)
+	}
+	if err == nil {
+		bs, err = extractScalars(bs, &m.cr, &m.d7)
+	}
+
+	return finishSMPMessage(bs, err)
+}
+
+func (m *smp4Message) tlv() TLV {
+	out := appendSMPPoints(nil, m.rb)
+	out = appendScalars(out, m.cr, m.d7)
+
+	return TLV{Type: tlvTypeSMP4, Value: out}
+}
+
+func (m *smp4Message) deserialize(bs []byte) error {
+	bs, err := extractSMPPoints(bs, &m.rb)
+	if err == nil {
+		bs, err = extractScalars(bs, &m.cr, &m.d7)
+	}
+
+	return finishSMPMessage(bs, err)
+}
+
+func finishSMPMessage(rest []byte, err error) error {
+	if err != nil {
+		return err
+	}
+
+	if len(rest) != 0 {
+		return errInvalidLength
+	}
+
+	return nil
+}
+
+// parseSMPMessage decodes the SMP message carried by a TLV
+func parseSMPMessage(t TLV) (smpMessage, error) {
+	var m interface {
+		smpMessage
+		deserialize([]byte) error
+	}
+
+	switch t.Type {
+	case tlvTypeSMP1:
+		m = &smp1Message{}
+	case tlvTypeSMP2:
+		m = &smp2Message{}
+	case tlvTypeSMP3:
+		m = &smp3Message{}
+	case tlvTypeSMP4:
+		m = &smp4Message{}
+	default:
+		return nil, errUnexpectedSMPMessage
+	}
+
+	if err := m.deserialize(t.Value); err != nil {
+		return nil, err
+	}
+
+	return m, nil
+}
@@ -0,0 +1,102 @@
+package otr4
+
+import (
+	"crypto/rand"
+
+	. "gopkg.in/check.v1"
+)
+
+func sendSMPMessage(c *C, m smpMessage) smpMessage {
+	parsed, err := parseSMPMessage(m.tlv())
+	c.Assert(err, IsNil)
+	return parsed
+}
+
+func (s *OTR4Suite) Test_SMPMessagesThroughTLVs(c *C) {
+	alice, bob := &smp{}, &smp{}
+	secret := shakeToScalar([]byte("secret"))
+
+	m1, err := alice.start(rand.Reader, secret, "what is the secret?")
+	c.Assert(err, IsNil)
+
+	parsed1 := sendSMPMessage(c, m1).(*smp1Message)
+	c.Assert(parsed1.question, Equals, "what is the secret?")
+	c.Assert(bob.receiveMessage1(parsed1), IsNil)
+
+	m2, err := bob.respond(rand.Reader, secret)
+	c.Assert(err, IsNil)
+
+	m3, err := alice.receiveMessage2(rand.Reader, sendSMPMessage(c, m2).(*smp2Message))
+	c.Assert(err, IsNil)
+
+	m4, bobResult, err := bob.receiveMessage3(rand.Reader, sendSMPMessage(c, m3).(*smp3Message))
+	c.Assert(err, IsNil)
+	c.Assert(bobResult, Equals, true)
+
+	aliceResult, err := alice.receiveMessage4(sendSMPMessage(c, m4).(*smp4Message))
+	c.Assert(err, IsNil)
+	c.Assert(aliceResult, Equals, true)
+}
+
+func (s *OTR4Suite) Test_SMP1WithoutQuestion(c *C) {
+	m1, err := (&smp{}).start(rand.Reader, shakeToScalar([]byte("secret")), "")
+	c.Assert(err, IsNil)
+
+	t := m1.tlv()
+	c.Assert(t.Type, Equals, tlvTypeSMP1)
+	c.Assert(t.Value[:4], DeepEquals, []byte{0x00, 0x00, 0x00, 0x00})
+
+	parsed := sendSMPMessage(c, m1).(*smp1Message)
+	c.Assert(parsed.question, Equals, "")
+}
+
+func (s *OTR4Suite) Test_ParseSMPMessageRejectsInvalidQuestions(c *C) {
+	m1, err := (&smp{}).start(rand.Reader, shakeToScalar([]byte("secret")), "?")
+	c.Assert(err, IsNil)
+
+	t := m1.tlv()
+	t.Value[4] = 0xff
+
+	_, err = parseSMPMessage(t)
+	c.Assert(err, Equals, errInvalidSMPQuestion)
+}
+
+func (s *OTR4Suite) Test_ParseSMPMessageRejectsInvalidValues(c *C) {
+	m := &smp4Message{
+		rb: generateTestKeyPair(c).pub.h,
+		cr: shakeToScalar([]byte("cr")),
+		d7: shakeToScalar([]byte("d7")),
+	}
+	t := m.tlv()
+	c.Assert(t.Value, HasLen, 3*fieldBytes)
+
+	_, err := parseSMPMessage(TLV{Type: tlvTypeSMP4, Value: t.Value[:len(t.Value)-1]})
+	c.Assert(err, Equals, errInvalidLength)
+
+	_, err = parseSMPMessage(TLV{Type: tlvTypeSMP4, Value: append(t.Value, 0x00)})
+	c.Assert(err, Equals, errInvalidLength)
+
+	nonCanonical := append([]byte{}, t.Value...)
+	for i := 2 * fieldBytes; i < 3*fieldBytes; i++ {
+		nonCanonical[i] = 0xff
+	}
+	_, err = parseSMPMessage(TLV{Type: tlvTypeSMP4, Value: nonCanonical})
+	c.Assert(err, Equals, errNonCanonicalScalar)
+
+	invalidPoint := append([]byte{}, t.Value...)
+	invalidPoint[0] ^= 0x01
+	_, err = parseSMPMessage(TLV{Type: tlvTypeSMP4, Value: invalidPoint})
+	c.Assert(err, NotNil)
+
+	_, err = parseSMPMessage(TLV{Type: tlvTypeSMPAbort})
+	c.Assert(err, Equals, errUnexpectedSMPMessage)
+}
+
+func (s *OTR4Suite) Test_ParseSMPMessageRejectsLowOrderPoints(c *C) {
+	m1, err := (&smp{}).start(rand.Reader, shakeToScalar([]byte("secret")), "")
+	c.Assert(err, IsNil)
+
+	_, err = parseSMPMessage(forcedSuccessSMP2(c, m1).tlv())
+
+	c.Assert(err, Equals, errInvalidPoint)
+}
@@ -1,6 +1,8 @@
 package otr4
 
 import (
+	"crypto/rand"
+
 	"github.com/twstrike/ed448"
 
 	. "gopkg.in/check.v1"
@@ -41,14 +43,15 @@ func (s *OTR4Suite) Test_GenerateDZKP(c *C) {
 	c.Assert(rslt, DeepEquals, exp)
 }
 
-func (s *OTR4Suite) Test_GenerateZKP(c *C) {
+func (s *OTR4Suite) Test_ProveKnowledge(c *C) {
 	b1 := [56]byte{0x04}
 	b2 := [56]byte{0x01}
 
 	r := ed448.NewScalar(b1[:])
 	a := ed448.NewScalar(b2[:])
 
-	cc, d := generateZKP(r, a, byte(01))
+	proof := smpKnowledge(ed448.PrecomputedScalarMul(a), byte(01)).proveWith([]ed448.Scalar{r}, []ed448.Scalar{a})
+	cc, d := proof.c, proof.d[0]
 
 	expC := ed448.NewScalar([]byte{
 		0xc4, 0x6b, 0x41, 0x3c, 0xd2, 0x70, 0xc9, 0xcb,
@@ -76,7 +79,7 @@ func (s *OTR4Suite) Test_GenerateZKP(c *C) {
 	c.Assert(d, DeepEquals, expD)
 }
 
-func (s *OTR4Suite) Test_VerifyZKP(c *C) {
+func (s *OTR4Suite) Test_VerifyKnowledge(c *C) {
 	cc := ed448.NewScalar([]byte{
 		0xc4, 0x6b, 0x41, 0x3c, 0xd2, 0x70, 0xc9, 0xcb,
 		0x86, 0x68, 0x18, 0x57, 0x67, 0x63, 0x36, 0xf0,
@@ -101,12 +104,12 @@ func (s *OTR4Suite) Test_VerifyZKP(c *C) {
 
 	gen := ed448.NewPointFromBytes()
 
-	ok := verifyZKP(dd, cc, gen, byte(01))
+	ok := smpKnowledge(gen, byte(01)).verify(newSigmaProof(cc, dd))
 
 	c.Assert(ok, Equals, false)
 }
 
-func (s *OTR4Suite) Test_VerifyZKP2(c *C) {
+func (s *OTR4Suite) Test_VerifyCoordinates(c *C) {
 	d5 := ed448.NewScalar([]byte{
 		0xc4, 0x6b, 0x41, 0x3c, 0xd2, 0x70, 0xc9, 0xcb,
 		0x86, 0x68, 0x18, 0x57, 0x67, 0x63, 0x36, 0xf0,
@@ -184,12 +187,12 @@ func (s *OTR4Suite) Test_VerifyZKP2(c *C) {
 	},
 	)
 
-	ok := verifyZKP2(g2, g3, pb, qb, d5, d6, cp, byte(1))
+	ok := smpCoordinates(g2, g3, pb, qb, byte(1)).verify(newSigmaProof(cp, d5, d6))
 
 	c.Assert(ok, Equals, false)
 }
 
-func (s *OTR4Suite) Test_VerifyZKP3(c *C) {
+func (s *OTR4Suite) Test_VerifyCoordinatesWithOtherValues(c *C) {
 	d5 := ed448.NewScalar([]byte{
 		0xc4, 0x6b, 0x41, 0x3c, 0xd2, 0x70, 0xc9, 0xcb,
 		0x86, 0x68, 0x18, 0x57, 0x67, 0x63, 0x36, 0xf0,
@@ -267,12 +270,12 @@ func (s *OTR4Suite) Test_VerifyZKP3(c *C) {
 	},
 	)
 
-	ok := verifyZKP3(g2, g3, pb, qb, d5, d6, cp, byte(1))
+	ok := smpCoordinates(g2, g3, pb, qb, byte(1)).verify(newSigmaProof(cp, d5, d6))
 
 	c.Assert(ok, Equals, false)
 }
 
-func (s *OTR4Suite) Test_VerifyZKP4(c *C) {
+func (s *OTR4Suite) Test_VerifyRatio(c *C) {
 	d7 := ed448.NewScalar([]byte{
 		0xc4, 0x6b, 0x41, 0x3c, 0xd2, 0x70, 0xc9, 0xcb,
 		0x86, 0x68, 0x18, 0x57, 0x67, 0x63, 0x36, 0xf0,
@@ -339,7 +342,281 @@ func (s *OTR4Suite) Test_VerifyZKP4(c *C) {
 	},
 	)
 
-	ok := verifyZKP4(g3a, qa, qb, ra, d7, cr, byte(1))
+	ok := smpRatio(g3a, qa, qb, ra, byte(1)).verify(newSigmaProof(cr, d7))
 
 	c.Assert(ok, Equals, false)
 }
+
+func runSMP(c *C, aliceSecret, bobSecret string) (bool, bool) {
+	alice, bob := &smp{}, &smp{}
+
+	m1, err := alice.start(rand.Reader, shakeToScalar([]byte(aliceSecret)), "")
+	c.Assert(err, IsNil)
+	c.Assert(bob.receiveMessage1(m1), IsNil)
+
+	m2, err := bob.respond(rand.Reader, shakeToScalar([]byte(bobSecret)))
+	c.Assert(err, IsNil)
+
+	m3, err := alice.receiveMessage2(rand.Reader, m2)
+	c.Assert(err, IsNil)
+
+	m4, bobResult, err := bob.receiveMessage3(rand.Reader, m3)
+	c.Assert(err, IsNil)
+
+	aliceResult, err := alice.receiveMessage4(m4)
+	c.Assert(err, IsNil)
+
+	c.Assert(alice.state, Equals, smpStateExpect1)
+	c.Assert(bob.state, Equals, smpStateExpect1)
+
+	return aliceResult, bobResult
+}
+
+func (s *OTR4Suite) Test_SMPSucceedsWithTheSameSecret(c *C) {
+	aliceResult, bobResult := runSMP(c, "secret", "secret")
+
+	c.Assert(aliceResult, Equals, true)
+	c.Assert(bobResult, Equals, true)
+}
+
+func (s *OTR4Suite) Test_SMPFailsWithDifferentSecrets(c *C) {
+	aliceResult, bobResult := runSMP(c, "secret", "another secret")
+
+	c.Assert(aliceResult, Equals, false)
+	c.Assert(bobResult, Equals, false)
+}
+
+func (s *OTR4Suite) Test_SMPDetectsInvalidProofs(c *C) {
+	alice, bob := &smp{}, &smp{}
+
+	m1, err := alice.start(rand.Reader, shakeToScalar([]byte("secret")), "")
+	c.Assert(err, IsNil)
+
+	m1.d2.Add(m1.d2, m1.c2)
+	c.Assert(bob.receiveMessage1(m1), Equals, errSMPCheated)
+	c.Assert(bob.received, IsNil)
+
+	m1, err = alice.start(rand.Reader, shakeToScalar([]byte("secret")), "")
+	c.Assert(err, IsNil)
+	c.Assert(bob.receiveMessage1(m1), IsNil)
+
+	m2, err := bob.respond(rand.Reader, shakeToScalar([]byte("secret")))
+	c.Assert(err, IsNil)
+
+	m2.pb = m2.qb
+	_, err = alice.receiveMessage2(rand.Reader, m2)
+
+	c.Assert(err, Equals, errSMPCheated)
+	c.Assert(alice.state, Equals, smpStateExpect1)
+}
+
+// forcedSuccessSMP2 is what a responder who does not know the secret can
+// send to make SMP succeed if low order points are accepted: g3b is the
+// identity, proven with the witness 0, so g3, Pa and Pb are the identity
+// too
+func forcedSuccessSMP2(c *C, m1 *smp1Message) *smp2Message {
+	identity := ed448.NewPointFromBytes()
+	zero := ed448.NewScalar()
+
+	b2, err := randScalar(rand.Reader)
+	c.Assert(err, IsNil)
+	r, err := randScalar(rand.Reader)
+	c.Assert(err, IsNil)
+	guess, err := randScalar(rand.Reader)
+	c.Assert(err, IsNil)
+
+	m := &smp2Message{g2b: ed448.PrecomputedScalarMul(b2), g3b: identity}
+	m.c2, m.d2, err = proveKnowledge(rand.Reader, m.g2b, b2, 3)
+	c.Assert(err, IsNil)
+	m.c3, m.d3, err = proveKnowledge(rand.Reader, m.g3b, zero, 4)
+	c.Assert(err, IsNil)
+
+	g2 := ed448.PointScalarMul(m1.g2a, b2)
+	m.pb = identity
+	m.qb = ed448.PointDoubleScalarMul(ed448.BasePoint, g2, r, guess)
+	proof, err := smpCoordinates(g2, identity, m.pb, m.qb, 5).prove(rand.Reader, r, guess)
+	c.Assert(err, IsNil)
+	m.cp, m.d5, m.d6 = proof.c, proof.d[0], proof.d[1]
+
+	return m
+}
+
+// forcedSuccessSMP4 answers the SMP3 message with Rb as the identity,
+// proven with the witness 0
+func forcedSuccessSMP4(c *C, m2 *smp2Message, m3 *smp3Message) *smp4Message {
+	identity := ed448.NewPointFromBytes()
+
+	proof, err := smpRatio(m2.g3b, m3.qa, m2.qb, identity, 8).prove(rand.Reader, ed448.NewScalar())
+	c.Assert(err, IsNil)
+
+	return &smp4Message{rb: identity, cr: proof.c, d7: proof.d[0]}
+}
+
+func (s *OTR4Suite) Test_SMPRejectsLowOrderValues(c *C) {
+	alice := &smp{}
+
+	m1, err := alice.start(rand.Reader, shakeToScalar([]byte("secret")), "")
+	c.Assert(err, IsNil)
+
+	_, err = alice.receiveMessage2(rand.Reader, forcedSuccessSMP2(c, m1))
+
+	c.Assert(err, Equals, errSMPCheated)
+	c.Assert(alice.state, Equals, smpStateExpect1)
+}
+
+func (s *OTR4Suite) Test_SMPRejectsUnexpectedMessages(c *C) {
+	alice, bob := &smp{}, &smp{}
+
+	_, err := bob.respond(rand.Reader, shakeToScalar([]byte("secret")))
+	c.Assert(err, Equals, errUnexpectedSMPMessage)
+
+	_, err = alice.receiveMessage2(rand.Reader, &smp2Message{})
+	c.Assert(err, Equals, errUnexpectedSMPMessage)
+
+	m1, err := alice.start(rand.Reader, shakeToScalar([]byte("secret")), "")
+	c.Assert(err, IsNil)
+
+	c.Assert(alice.receiveMessage1(m1), Equals, errUnexpectedSMPMessage)
+	c.Assert(alice.state, Equals, smpStateExpect1)
+}
+
+func (s *OTR4Suite) Test_SMPAbort(c *C) {
+	alice := &smp{}
+
+	_, err := alice.start(rand.Reader, shakeToScalar([]byte("secret")), "")
+	c.Assert(err, IsNil)
+	c.Assert(alice.state, Equals, smpStateExpect2)
+
+	alice.abort()
+
+	c.Assert(alice.state, Equals, smpStateExpect1)
+	c.Assert(alice.secret, IsNil)
+}
+
+func (s *OTR4Suite) Test_NormalizeSMPAnswer(c *C) {
+	c.Assert(normalizeSMPAnswer("\tcafe\u0301 \n"), DeepEquals, []byte("caf\u00e9"))
+	c.Assert(normalizeSMPAnswer("two words"), DeepEquals, []byte("two words"))
+}
+
+func (s *OTR4Suite) Test_SMPSecretIsBoundToTheSession(c *C) {
+	aliceID, err := GenerateIdentity(rand.Reader)
+	c.Assert(err, IsNil)
+	bobID, err := GenerateIdentity(rand.Reader)
+	c.Assert(err, IsNil)
+
+	alice, bob := &Conversation{Identity: aliceID}, &Conversation{Identity: bobID}
+	establishSessionBetween(c, alice, bob)
+
+	aliceSecret, err := alice.smpSecret(true, "café")
+	c.Assert(err, IsNil)
+	bobSecret, err := bob.smpSecret(false, " cafe\u0301\n")
+	c.Assert(err, IsNil)
+
+	c.Assert(bobSecret.Equals(aliceSecret), Equals, true)
+
+	swapped, err := bob.smpSecret(true, "café")
+	c.Assert(err, IsNil)
+	c.Assert(swapped.Equals(aliceSecret), Equals, false)
+
+	aliceFP, bobFP := aliceID.Fingerprint(), bobID.Fingerprint()
+	expected := generateSMPsecret(aliceFP[:], bobFP[:], alice.ssid, []byte("café"))
+	c.Assert(aliceSecret.Equals(ed448.NewScalar(expected[:fieldBytes])), Equals, true)
+
+	otherAlice, otherBob := &Conversation{Identity: aliceID}, &Conversation{Identity: bobID}
+	establishSessionBetween(c, otherAlice, otherBob)
+
+	otherSession, err := otherAlice.smpSecret(true, "café")
+	c.Assert(err, IsNil)
+	c.Assert(otherSession.Equals(aliceSecret), Equals, false)
+}
+
+func (s *OTR4Suite) Test_SMPSecretNeedsAnEncryptedConversation(c *C) {
+	_, err := (&Conversation{}).smpSecret(true, "answer")
+	c.Assert(err, Equals, errUnexpectedState)
+
+	_, err = (&Conversation{}).StartSMP("", "answer")
+	c.Assert(err, Equals, errUnexpectedState)
+}
+
+func receiveOne(c *C, conv *Conversation, toSend [][]byte) [][]byte {
+	c.Assert(toSend, HasLen, 1)
+
+	plain, reply, err := conv.Receive(toSend[0])
+	c.Assert(err, IsNil)
+	c.Assert(plain, IsNil)
+
+	return reply
+}
+
+func (s *OTR4Suite) Test_SMPOverAConversation(c *C) {
+	alice, bob := establishSession(c)
+
+	toSend, err := alice.StartSMP("favourite food?", "pizza")
+	c.Assert(err, IsNil)
+
+	reply := receiveOne(c, bob, toSend)
+	c.Assert(reply, IsNil)
+	c.Assert(bob.smp.received.question, Equals, "favourite food?")
+
+	toSend, err = bob.RespondSMP(" pizza ")
+	c.Assert(err, IsNil)
+	c.Assert(bob.smp.state, Equals, smpStateExpect3)
+
+	toSend = receiveOne(c, alice, toSend)
+	c.Assert(alice.smp.state, Equals, smpStateExpect4)
+
+	toSend = receiveOne(c, bob, toSend)
+	c.Assert(bob.smp.state, Equals, smpStateExpect1)
+
+	reply = receiveOne(c, alice, toSend)
+	c.Assert(reply, IsNil)
+	c.Assert(alice.smp.state, Equals, smpStateExpect1)
+}
+
+func (s *OTR4Suite) Test_AbortSMPOverAConversation(c *C) {
+	alice, bob := establishSession(c)
+
+	toSend, err := alice.StartSMP("", "pizza")
+	c.Assert(err, IsNil)
+	c.Assert(receiveOne(c, bob, toSend), IsNil)
+
+	toSend, err = bob.AbortSMP()
+	c.Assert(err, IsNil)
+	c.Assert(bob.smp.received, IsNil)
+
+	c.Assert(receiveOne(c, alice, toSend), IsNil)
+	c.Assert(alice.smp.state, Equals, smpStateExpect1)
+}
+
+func (s *OTR4Suite) Test_UnexpectedSMPMessagesAreAnsweredWithAnAbort(c *C) {
+	alice, bob := establishSession(c)
+
+	m1, err := (&smp{}).start(rand.Reader, shakeToScalar([]byte("secret")), "")
+	c.Assert(err, IsNil)
+
+	alice.smp.state = smpStateExpect2
+	toSend, err := bob.sendTLVs(m1.tlv())
+	c.Assert(err, IsNil)
+
+	reply := receiveOne(c, alice, toSend)
+	c.Assert(alice.smp.state, Equals, smpStateExpect1)
+
+	c.Assert(reply, HasLen, 1)
+	c.Assert(receivedTLVs(c, bob, reply[0]), DeepEquals, []TLV{{Type: tlvTypeSMPAbort, Value: []byte{}}})
+}
+
+// receivedTLVs decrypts a data message without handling its TLVs
+func receivedTLVs(c *C, conv *Conversation, wire []byte) []TLV {
+	msg, err := decodeMessage(wire)
+	c.Assert(err, IsNil)
+	body, h, err := parseMessageHeader(msg)
+	c.Assert(err, IsNil)
+	c.Assert(h.typ, Equals, dataMsgType)
+
+	plaintext, err := conv.receiveDataMessage(msg, body)
+	c.Assert(err, IsNil)
+	_, tlvs, err := splitMessage(plaintext)
+	c.Assert(err, IsNil)
+
+	return tlvs
+}
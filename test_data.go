@@ -165,7 +165,7 @@ var (
 	}
 
 	testPrivA = &privateKey{
-		ed448.NewScalar([]byte{
+		r: ed448.NewScalar([]byte{
 			0x13, 0x66, 0x00, 0x41, 0x14, 0x93, 0x97, 0x66,
 			0x8a, 0x8d, 0xf2, 0xd3, 0x20, 0x77, 0xa6, 0x5e,
 			0x9b, 0x5f, 0x97, 0x7c, 0x39, 0x34, 0xbe, 0xf3,
@@ -206,7 +206,7 @@ var (
 	}
 
 	testPrivB = &privateKey{
-		ed448.NewScalar([]byte{
+		r: ed448.NewScalar([]byte{
 			0xb9, 0x1c, 0xa1, 0xe6, 0x54, 0xb5, 0xdc, 0x03,
 			0x11, 0x0e, 0x6f, 0xa8, 0x52, 0x6b, 0x3d, 0x7c,
 			0x46, 0xbd, 0xd6, 0x1b, 0x52, 0x8b, 0x18, 0xa4,
@@ -0,0 +1,156 @@
+package otr4
+
+import "bytes"
+
+const (
+	tlvTypePadding      uint16 = 0x0000
+	tlvTypeDisconnected uint16 = 0x0001
+	tlvTypeSMP1         uint16 = 0x0002
+	tlvTypeSMP2         uint16 = 0x0003
+	tlvTypeSMP3         uint16 = 0x0004
+	tlvTypeSMP4         uint16 = 0x0005
+	tlvTypeSMPAbort     uint16 = 0x0006
+)
+
+// TLVTypeExtraSymmetricKey is the type of the TLV announcing the use of
+// the extra symmetric key. It is passed to the handler registered for it,
+// as what the key is used for is up to the host application.
+const TLVTypeExtraSymmetricKey uint16 = 0x0007
+
+const tlvHeaderBytes = 4
+
+// TLV is a type-length-value record sent along with the message in a data
+// message
+type TLV struct {
+	Type  uint16
+	Value []byte
+}
+
+// TLVHandler is called with every received TLV of the type it was
+// registered for
+type TLVHandler func(tlv TLV) error
+
+func (t TLV) serialize() []byte {
+	out := appendWord16(nil, t.Type)
+	out = appendWord16(out, uint16(len(t.Value)))
+	return append(out, t.Value...)
+}
+
+func (t TLV) isValid() bool {
+	return len(t.Value) <= 0xFFFF
+}
+
+func parseTLVs(bs []byte) ([]TLV, error) {
+	var tlvs []TLV
+
+	for len(bs) > 0 {
+		if len(bs) < tlvHeaderBytes {
+			return nil, errInvalidTLV
+		}
+
+		var t TLV
+		var l uint16
+		bs, t.Type, _ = extractWord16(bs)
+		bs, l, _ = extractWord16(bs)
+
+		if len(bs) < int(l) {
+			return nil, errInvalidTLV
+		}
+
+		t.Value = bs[:l]
+		bs = bs[l:]
+		tlvs = append(tlvs, t)
+	}
+
+	return tlvs, nil
+}
+
+// joinMessage builds the plaintext of a data message: the message, then a
+// NUL byte and the TLVs, if there are any
+func joinMessage(msg []byte, tlvs []TLV) ([]byte, error) {
+	out := append([]byte{}, msg...)
+	if len(tlvs) == 0 {
+		return out, nil
+	}
+
+	out = append(out, 0x00)
+	for _, t := range tlvs {
+		if !t.isValid() {
+			return nil, errInvalidTLV
+		}
+		out = append(out, t.serialize()...)
+	}
+
+	return out, nil
+}
+
+func splitMessage(plaintext []byte) ([]byte, []TLV, error) {
+	i := bytes.IndexByte(plaintext, 0x00)
+	if i == -1 {
+		return plaintext, nil, nil
+	}
+
+	tlvs, err := parseTLVs(plaintext[i+1:])
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return plaintext[:i], tlvs, nil
+}
+
+func ignoreTLV(c *Conversation, t TLV) error {
+	return nil
+}
+
+// builtinTLVHandlers handles the TLV types defined by the protocol. They
+// cannot be overridden.
+var builtinTLVHandlers = map[uint16]func(*Conversation, TLV) error{
+	tlvTypePadding:      ignoreTLV,
+	tlvTypeDisconnected: (*Conversation).receiveDisconnected,
+	tlvTypeSMP1:         (*Conversation).receiveSMP,
+	tlvTypeSMP2:         (*Conversation).receiveSMP,
+	tlvTypeSMP3:         (*Conversation).receiveSMP,
+	tlvTypeSMP4:         (*Conversation).receiveSMP,
+	tlvTypeSMPAbort:     (*Conversation).receiveSMP,
+}
+
+// RegisterTLVHandler sets the handler called for received TLVs of the
+// given type. TLVs without a handler are ignored.
+func (c *Conversation) RegisterTLVHandler(typ uint16, h TLVHandler) error {
+	if _, ok := builtinTLVHandlers[typ]; ok {
+		return errReservedTLVType
+	}
+
+	if c.tlvHandlers == nil {
+		c.tlvHandlers = make(map[uint16]TLVHandler)
+	}
+
+	c.tlvHandlers[typ] = h
+	return nil
+}
+
+func (c *Conversation) handleTLVs(tlvs []TLV) error {
+	for _, t := range tlvs {
+		if h, ok := builtinTLVHandlers[t.Type]; ok {
+			if err := h(c, t); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if h, ok := c.tlvHandlers[t.Type]; ok && h != nil {
+			if err := h(t); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func (c *Conversation) receiveDisconnected(t TLV) error {
+	c.smp.abort()
+	c.ratchet = nil
+	c.msgState = finished
+	return nil
+}
@@ -0,0 +1,125 @@
+package otr4
+
+import (
+	. "gopkg.in/check.v1"
+)
+
+func (s *OTR4Suite) Test_TLVSerialization(c *C) {
+	t := TLV{Type: 0x0102, Value: []byte{0x03, 0x04, 0x05}}
+
+	c.Assert(t.serialize(), DeepEquals, []byte{0x01, 0x02, 0x00, 0x03, 0x03, 0x04, 0x05})
+}
+
+func (s *OTR4Suite) Test_JoinAndSplitMessage(c *C) {
+	tlvs := []TLV{
+		{Type: tlvTypePadding, Value: []byte{0x00, 0x00}},
+		{Type: 0x0100, Value: []byte("value")},
+		{Type: tlvTypeDisconnected},
+	}
+
+	plaintext, err := joinMessage([]byte("hello"), tlvs)
+	c.Assert(err, IsNil)
+
+	msg, parsed, err := splitMessage(plaintext)
+	c.Assert(err, IsNil)
+	c.Assert(msg, DeepEquals, []byte("hello"))
+	c.Assert(parsed, HasLen, 3)
+	c.Assert(parsed[0].Type, Equals, tlvTypePadding)
+	c.Assert(parsed[1], DeepEquals, tlvs[1])
+	c.Assert(parsed[2].Type, Equals, tlvTypeDisconnected)
+	c.Assert(parsed[2].Value, HasLen, 0)
+}
+
+func (s *OTR4Suite) Test_JoinMessageWithoutTLVs(c *C) {
+	plaintext, err := joinMessage([]byte("hello"), nil)
+
+	c.Assert(err, IsNil)
+	c.Assert(plaintext, DeepEquals, []byte("hello"))
+}
+
+func (s *OTR4Suite) Test_JoinMessageRejectsTooLongValues(c *C) {
+	_, err := joinMessage(nil, []TLV{{Type: 0x0100, Value: make([]byte, 0x10000)}})
+
+	c.Assert(err, Equals, errInvalidTLV)
+}
+
+func (s *OTR4Suite) Test_SplitMessageRejectsMalformedTLVs(c *C) {
+	_, _, err := splitMessage([]byte{'h', 'i', 0x00, 0x00, 0x01, 0x00})
+	c.Assert(err, Equals, errInvalidTLV)
+
+	_, _, err = splitMessage([]byte{'h', 'i', 0x00, 0x00, 0x01, 0x00, 0x02, 0x01})
+	c.Assert(err, Equals, errInvalidTLV)
+
+	_, _, err = splitMessage([]byte{'h', 'i', 0x00, 0x00, 0x01, 0x00, 0x00, 0x00})
+	c.Assert(err, Equals, errInvalidTLV)
+}
+
+func (s *OTR4Suite) Test_RegisterTLVHandlerRejectsBuiltinTypes(c *C) {
+	con := &Conversation{}
+
+	for typ := range builtinTLVHandlers {
+		c.Assert(con.RegisterTLVHandler(typ, func(TLV) error { return nil }), Equals, errReservedTLVType)
+	}
+}
+
+func (s *OTR4Suite) Test_RegisteredTLVHandlersAreCalled(c *C) {
+	alice, bob := establishSession(c)
+
+	var received []TLV
+	err := alice.RegisterTLVHandler(0x0100, func(t TLV) error {
+		received = append(received, t)
+		return nil
+	})
+	c.Assert(err, IsNil)
+
+	toSend, err := bob.SendWithTLVs([]byte("hi"), []TLV{
+		{Type: tlvTypePadding, Value: make([]byte, 10)},
+		{Type: 0x0100, Value: []byte("app")},
+		{Type: 0x0101, Value: []byte("unknown")},
+	})
+	c.Assert(err, IsNil)
+
+	plain, _, err := alice.Receive(toSend[0])
+	c.Assert(err, IsNil)
+	c.Assert(plain, DeepEquals, []byte("hi"))
+	c.Assert(received, DeepEquals, []TLV{{Type: 0x0100, Value: []byte("app")}})
+}
+
+func (s *OTR4Suite) Test_SendWithTLVsFailsInPlaintext(c *C) {
+	_, err := (&Conversation{}).SendWithTLVs([]byte("hi"), []TLV{{Type: 0x0100}})
+
+	c.Assert(err, Equals, errUnexpectedState)
+}
+
+func (s *OTR4Suite) Test_ExtraSymmetricKeyTLVIsPassedToTheHost(c *C) {
+	alice, bob := establishSession(c)
+
+	var received []TLV
+	err := alice.RegisterTLVHandler(TLVTypeExtraSymmetricKey, func(t TLV) error {
+		received = append(received, t)
+		return nil
+	})
+	c.Assert(err, IsNil)
+
+	toSend, err := bob.SendWithTLVs(nil, []TLV{{Type: TLVTypeExtraSymmetricKey, Value: []byte{0x00, 0x00, 0x00, 0x01}}})
+	c.Assert(err, IsNil)
+
+	_, _, err = alice.Receive(toSend[0])
+	c.Assert(err, IsNil)
+	c.Assert(received, DeepEquals, []TLV{{Type: TLVTypeExtraSymmetricKey, Value: []byte{0x00, 0x00, 0x00, 0x01}}})
+}
+
+func (s *OTR4Suite) Test_ReceiveReturnsThePlaintextWhenATLVHandlerFails(c *C) {
+	alice, bob := establishSession(c)
+
+	failure := newOtrError("handler failed")
+	c.Assert(alice.RegisterTLVHandler(0x0100, func(TLV) error { return failure }), IsNil)
+
+	toSend, err := bob.SendWithTLVs([]byte("hi"), []TLV{{Type: 0x0100}})
+	c.Assert(err, IsNil)
+
+	plain, reply, err := alice.Receive(toSend[0])
+	c.Assert(err, Equals, failure)
+	c.Assert(plain, DeepEquals, []byte("hi"))
+	c.Assert(reply, IsNil)
+}
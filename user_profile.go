@@ -1,181 +1,337 @@
 package otr4
 
-//
-//import (
-//	"io"
-//	"strings"
-//	"time"
-//
-//	"github.com/twstrike/ed448"
-//)
-//
-//type signature [sigBytes]byte
-//
-//type dsaSignature [dsaSigBytes]byte
-//
-//type userProfile struct {
-//	// change to set
-//	versions []byte
-//	longPub  *publicKey
-//	pub      *publicKey
-//	// Date
-//	expiration    int64
-//	transitionSig *dsaSignature
-//	sig           *signature
-//}
-//
-//// XXX: make this not a method of conversation
-//// XXX: add transitionSignature
-//func (c *conversation) newProfile(v string, keyPair *cramerShoupKeyPair) (*userProfile, error) {
-//	profile, err := createProfileBody(v, keyPair)
-//	if err != nil {
-//		return nil, err
-//	}
-//
-//	err = profile.sign(c.rand(), keyPair)
-//	if err != nil {
-//		return nil, err
-//	}
-//
-//	return profile, nil
-//}
-//
-//// XXX: add transitionSignature
-//func createProfileBody(v string, keyPair *cramerShoupKeyPair) (*userProfile, error) {
-//	if len(v) == 0 {
-//		return nil, errInvalidVersion
-//	}
-//
-//	v1, v2 := "1", "2"
-//	if strings.Contains(v, v1) || strings.Contains(v, v2) {
-//		return nil, errInvalidVersion
-//	}
-//
-//	t := time.Now().Unix() + int64(1209600)
-//
-//	profile := &userProfile{
-//		versions:   v,
-//		pub:        keyPair.pub,
-//		expiration: t,
-//		sig:        &signature{},
-//	}
-//
-//	return profile, nil
-//}
-//
-//func (profile *userProfile) sign(rand io.Reader, keyPair *cramerShoupKeyPair) error {
-//	sym, err := randSymKey(rand)
-//	if err != nil {
-//		// XXX: set profile to nil?
-//		return err
-//	}
-//
-//	var k []byte
-//	k = appendBytes(k, keyPair.priv.z.Encode())
-//	k = appendBytes(k, keyPair.pub.h.Encode())
-//	k = appendBytes(k, sym)
-//
-//	var key [144]byte
-//	copy(key[:], k)
-//
-//	c := ed448.NewDecafCurve()
-//	body := serializeBody(profile)
-//
-//	sig, valid := c.Sign(key, body)
-//	if !valid {
-//		return errCorruptEncryptedSignature
-//	}
-//
-//	for i, b := range sig {
-//		profile.sig[i] = b
-//	}
-//
-//	return nil
-//}
-//
-//func (profile *userProfile) verify(pub *cramerShoupPublicKey) (bool, error) {
-//	c := ed448.NewDecafCurve()
-//	body := serializeBody(profile)
-//
-//	var pubKey [fieldBytes]byte
-//	copy(pubKey[:], pub.h.Encode())
-//
-//	valid, err := c.Verify(serializeSignature(profile.sig), body, pubKey)
-//	if err != nil {
-//		return valid, err
-//	}
-//
-//	return valid, nil
-//}
-//
-//func serializeSignature(data *signature) [112]byte {
-//	var b [112]byte
-//	copy(b[:], data[:])
-//
-//	return b
-//}
-//
-//func deserializeSignature(bs []byte) *signature {
-//	sig := &signature{}
-//
-//	for i, b := range bs {
-//		sig[i] = b
-//	}
-//	return sig
-//}
-//
-//func deserializeTransSignature(bs []byte) *dsaSignature {
-//	sig := &dsaSignature{}
-//
-//	for i, b := range bs {
-//		sig[i] = b
-//	}
-//	return sig
-//}
-//
-//func serializeBody(profile *userProfile) []byte {
-//	var out []byte
-//
-//	out = appendData(out, parseToByte(profile.versions))
-//	out = appendBytes(out, profile.pub.serialize())
-//	out = appendWord64(out, profile.expiration)
-//
-//	if profile.transitionSig != nil {
-//		out = appendSignature(out, profile.transitionSig)
-//	}
-//
-//	return out
-//}
-//
-//func (profile *userProfile) serialize() []byte {
-//	var out []byte
-//
-//	out = serializeBody(profile)
-//	out = appendSignature(out, profile.sig)
-//
-//	return out
-//}
-//
-//func deserializeProfile(ser []byte) *userProfile {
-//	var err error
-//	profile := &userProfile{}
-//
-//	// XXX: make those numbers not magical
-//	// XXX:do not ignore errors?
-//	cursor := 6
-//	_, rslt, _ := extractData(ser[:cursor])
-//	profile.versions = bytesToString(rslt)
-//
-//	profile.pub, err = deserialize(ser[cursor : cursor+170])
-//	if err != nil {
-//		return nil
-//	}
-//
-//	_, expiration, _ := extractWord64(ser[cursor+170 : cursor+170+8])
-//	profile.expiration = int64(expiration)
-//
-//	profile.transitionSig = deserializeTransSignature(ser[184:224])
-//	profile.sig = deserializeSignature(ser[224:])
-//
-//	return profile
-//}
+import (
+	"bytes"
+	"crypto/dsa"
+	"io"
+	"strings"
+	"time"
+)
+
+// profileExpiration is how long a newly created profile is valid for
+const profileExpiration = 14 * 24 * time.Hour
+
+// defaultProfileClockSkew is used when the conversation does not set one
+const defaultProfileClockSkew = 5 * time.Minute
+
+type signature [signatureSize]byte
+
+type dsaSignature [dsaSigBytes]byte
+
+// userProfile is the client profile: it publishes the versions a client
+// supports and its long-term public key, signed by that same key
+type userProfile struct {
+	versions    string
+	instanceTag uint32
+	pub         *publicKey
+	// expiration is a Unix timestamp
+	expiration int64
+	// dsaKey and transitionSig are optional. They link an OTRv3 identity
+	// to this profile.
+	dsaKey        *dsa.PublicKey
+	transitionSig *dsaSignature
+	sig           *signature
+}
+
+func newProfile(versions string, instanceTag uint32, keys *keyPair) (*userProfile, error) {
+	profile, err := createProfileBody(versions, instanceTag, &keys.pub)
+	if err != nil {
+		return nil, err
+	}
+
+	err = profile.sign(keys)
+	if err != nil {
+		return nil, err
+	}
+
+	return profile, nil
+}
+
+// ourProfile returns the profile sent in the DAKE, which can come from the
+// identity. A new one is signed when there is none yet, when it has
+// expired, or when it lacks the transitional signature of TransitionKey.
+func (c *Conversation) ourProfile() (*userProfile, error) {
+	keys, err := c.ourKeys()
+	if err != nil {
+		return nil, err
+	}
+
+	tag, err := c.ourInstanceTag()
+	if err != nil {
+		return nil, err
+	}
+
+	p := c.profile
+	if p == nil && c.Identity != nil {
+		p = c.Identity.profile
+	}
+
+	if p != nil && p.instanceTag == tag && p.pub.h.Equals(keys.pub.h) &&
+		time.Now().Unix() < p.expiration && c.hasOurTransition(p) {
+		c.profile = p
+		return p, nil
+	}
+
+	p, err = createProfileBody("4", tag, &keys.pub)
+	if err != nil {
+		return nil, err
+	}
+
+	// the transitional signature is covered by the profile signature, so
+	// it comes first
+	if c.TransitionKey != nil {
+		if err := p.signTransition(c.rand(), c.TransitionKey); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := p.sign(keys); err != nil {
+		return nil, err
+	}
+
+	c.profile = p
+	return p, nil
+}
+
+// hasOurTransition reports whether the profile carries a transitional
+// signature by our TransitionKey, when we have one
+func (c *Conversation) hasOurTransition(p *userProfile) bool {
+	if c.TransitionKey == nil {
+		return true
+	}
+
+	return p.dsaKey != nil && p.transitionSig != nil &&
+		bytes.Equal(otrV3Fingerprint(p.dsaKey), otrV3Fingerprint(&c.TransitionKey.PublicKey))
+}
+
+// VerifyTransition checks that the profile the peer sent in the DAKE was
+// signed by the OTRv3 key with the given fingerprint, so a peer known by
+// that fingerprint can be trusted under its new key. It fails with
+// ErrTransitionFingerprintMismatch if the profile was signed by another
+// OTRv3 key, and with ErrInvalidTransitionSignature if it was not signed
+// by one at all.
+func (c *Conversation) VerifyTransition(fingerprint []byte) error {
+	if c.theirProfile == nil {
+		return errUnexpectedState
+	}
+
+	return c.theirProfile.verifyTransition(fingerprint)
+}
+
+func createProfileBody(versions string, instanceTag uint32, pub *publicKey) (*userProfile, error) {
+	if len(versions) == 0 || strings.ContainsAny(versions, "12") {
+		return nil, errInvalidVersion
+	}
+
+	profile := &userProfile{
+		versions:    versions,
+		instanceTag: instanceTag,
+		pub:         pub,
+		expiration:  time.Now().Add(profileExpiration).Unix(),
+	}
+
+	return profile, nil
+}
+
+func (profile *userProfile) sign(keys *keyPair) error {
+	sig, err := keys.priv.Sign(profile.serializeBody(), nil)
+	if err != nil {
+		return err
+	}
+
+	profile.sig = &signature{}
+	copy(profile.sig[:], sig)
+
+	return nil
+}
+
+// verify checks the profile was signed by the long-term key it publishes
+func (profile *userProfile) verify() error {
+	if profile.sig == nil || profile.pub == nil {
+		return ErrInvalidProfileSignature
+	}
+
+	if !profile.pub.Verify(profile.serializeBody(), nil, profile.sig[:]) {
+		return ErrInvalidProfileSignature
+	}
+
+	return nil
+}
+
+// validate checks that the profile can be trusted at the given time. It
+// returns one of the profile errors, so the reason the profile was
+// rejected can be shown to the user. The profile is still accepted for
+// skew after it expires.
+func (profile *userProfile) validate(now time.Time, skew time.Duration) error {
+	if profile.pub == nil || profile.pub.h == nil || !isValidPublicKey(profile.pub) {
+		return ErrInvalidProfileKey
+	}
+
+	if !strings.Contains(profile.versions, "4") || strings.ContainsAny(profile.versions, "12") {
+		return ErrInvalidProfileVersions
+	}
+
+	if now.After(time.Unix(profile.expiration, 0).Add(skew)) {
+		return ErrExpiredProfile
+	}
+
+	if profile.transitionSig != nil {
+		if profile.dsaKey == nil || !dsaVerify(profile.dsaKey, profile.serializeTransitionBody(), profile.transitionSig) {
+			return ErrInvalidTransitionSignature
+		}
+	}
+
+	return profile.verify()
+}
+
+// signTransition signs the profile with an OTRv3 DSA key, so peers who
+// trusted that key can trust the profile. It has to be done before the
+// profile is signed.
+func (profile *userProfile) signTransition(rand io.Reader, key *dsa.PrivateKey) error {
+	profile.dsaKey = &key.PublicKey
+
+	sig, err := dsaSign(rand, key, profile.serializeTransitionBody())
+	if err != nil {
+		profile.dsaKey = nil
+		return err
+	}
+
+	profile.transitionSig = sig
+	return nil
+}
+
+// verifyTransition checks the profile was signed by the OTRv3 key with the
+// given fingerprint
+func (profile *userProfile) verifyTransition(fingerprint []byte) error {
+	if profile.dsaKey == nil || profile.transitionSig == nil {
+		return ErrInvalidTransitionSignature
+	}
+
+	if !bytes.Equal(otrV3Fingerprint(profile.dsaKey), fingerprint) {
+		return ErrTransitionFingerprintMismatch
+	}
+
+	if !dsaVerify(profile.dsaKey, profile.serializeTransitionBody(), profile.transitionSig) {
+		return ErrInvalidTransitionSignature
+	}
+
+	return nil
+}
+
+// validateFrom validates a profile received in a message from the given
+// sender instance tag
+func (profile *userProfile) validateFrom(sender uint32, now time.Time, skew time.Duration) error {
+	if profile.instanceTag != sender {
+		return ErrProfileInstanceTagMismatch
+	}
+
+	return profile.validate(now, skew)
+}
+
+// validateTheirProfile validates a profile the peer sent in the DAKE. The
+// error returned is a ProfileError, which Receive hands to the host.
+func (c *Conversation) validateTheirProfile(profile *userProfile, sender uint32) error {
+	skew := c.ProfileClockSkew
+	if skew == 0 {
+		skew = defaultProfileClockSkew
+	}
+
+	return profile.validateFrom(sender, time.Now(), skew)
+}
+
+// serializeTransitionBody returns the fields covered by the transitional
+// signature
+func (profile *userProfile) serializeTransitionBody() []byte {
+	out := appendData(nil, parseToByte(profile.versions))
+	out = appendWord32(out, profile.instanceTag)
+	out = append(out, profile.pub.serialize()...)
+	out = appendWord64(out, profile.expiration)
+
+	if profile.dsaKey != nil {
+		return appendData(out, serializeDSAPublicKey(profile.dsaKey))
+	}
+
+	return appendData(out, nil)
+}
+
+func (profile *userProfile) serializeBody() []byte {
+	out := profile.serializeTransitionBody()
+
+	if profile.transitionSig != nil {
+		return appendData(out, profile.transitionSig[:])
+	}
+
+	return appendData(out, nil)
+}
+
+func (profile *userProfile) serialize() []byte {
+	return appendSignature(profile.serializeBody(), profile.sig)
+}
+
+// deserializeProfile parses a profile and returns the bytes following it
+func deserializeProfile(ser []byte) (*userProfile, []byte, error) {
+	profile := &userProfile{}
+
+	cursor, versions, ok := extractData(ser)
+	if !ok {
+		return nil, nil, errInvalidLength
+	}
+	profile.versions = bytesToString(versions)
+
+	cursor, profile.instanceTag, ok = extractWord32(cursor)
+	if !ok {
+		return nil, nil, errInvalidLength
+	}
+
+	var err error
+	profile.pub, cursor, err = deserializePublicKey(cursor)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	cursor, expiration, ok := extractWord64(cursor)
+	if !ok {
+		return nil, nil, errInvalidLength
+	}
+	profile.expiration = int64(expiration)
+
+	cursor, dsaKey, ok := extractData(cursor)
+	if !ok {
+		return nil, nil, errInvalidLength
+	}
+
+	if len(dsaKey) > 0 {
+		var rest []byte
+		profile.dsaKey, rest, err = deserializeDSAPublicKey(dsaKey)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		if len(rest) != 0 {
+			return nil, nil, errInvalidLength
+		}
+	}
+
+	cursor, transitionSig, ok := extractData(cursor)
+	if !ok {
+		return nil, nil, errInvalidLength
+	}
+
+	switch len(transitionSig) {
+	case 0:
+	case dsaSigBytes:
+		profile.transitionSig = &dsaSignature{}
+		copy(profile.transitionSig[:], transitionSig)
+	default:
+		return nil, nil, errInvalidLength
+	}
+
+	if len(cursor) < signatureSize {
+		return nil, nil, errInvalidLength
+	}
+
+	profile.sig = &signature{}
+	copy(profile.sig[:], cursor)
+
+	return profile, cursor[signatureSize:], nil
+}